@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+type cacheEntryInfo struct {
+	Key  string  `json:"key"`
+	Type string  `json:"type"`
+	TTL  float64 `json:"ttl,omitempty"`
+}
+
+func (c *Convergence) admin(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// hide the endpoints unless a token is configured
+		if c.AdminToken == "" {
+			c.showError(w, r, ErrNotFound)
+			return
+		}
+
+		if !matchSecret(bearerToken(r), c.AdminToken) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			c.showAPIError(w, r, ErrUnauthorized)
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+func (c *Convergence) webhook(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// hide the endpoint unless a secret or token is configured
+		if c.WebhookSecret == "" && c.AdminToken == "" {
+			c.showError(w, r, ErrNotFound)
+			return
+		}
+
+		// webhooks can often only be configured with a url
+		secret := r.Header.Get("X-Webhook-Secret")
+		if secret == "" {
+			secret = r.URL.Query().Get("secret")
+		}
+
+		if !matchSecret(secret, c.WebhookSecret) && !matchSecret(bearerToken(r), c.AdminToken) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			c.showAPIError(w, r, ErrUnauthorized)
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+func bearerToken(r *http.Request) string {
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+func matchSecret(value, secret string) bool {
+	// unset secrets never match
+	return secret != "" && subtle.ConstantTimeCompare([]byte(value), []byte(secret)) == 1
+}
+
+func (c *Convergence) handleCacheStats(w http.ResponseWriter, r *http.Request) {
+	cache := c.confluence.cache()
+	keys := cache.Keys(r.URL.Query().Get("prefix"))
+	sort.Strings(keys)
+
+	types := make(map[string]int)
+	entries := make([]cacheEntryInfo, 0, len(keys))
+
+	for _, key := range keys {
+		entry := cacheEntryInfo{
+			Key:  key,
+			Type: cacheType(key),
+		}
+
+		// not all caches know when entries expire
+		if expiring, ok := cache.(expiringCache); ok {
+			if ttl, ok := expiring.TTL(key); ok {
+				entry.TTL = ttl.Seconds()
+			}
+		}
+
+		types[entry.Type]++
+		entries = append(entries, entry)
+	}
+
+	c.render.JSON(w, http.StatusOK, map[string]interface{}{
+		"count":   len(entries),
+		"types":   types,
+		"entries": entries,
+	})
+}
+
+func (c *Convergence) handleCacheFlush(w http.ResponseWriter, r *http.Request) {
+	c.confluence.Reset()
+
+	w.WriteHeader(http.StatusNoContent)
+}
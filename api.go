@@ -0,0 +1,170 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pressly/chi"
+)
+
+// expansions that are cheap and map onto page fields
+var DefaultAPIExpand = []string{"body.storage", "metadata.labels"}
+
+func (c *Convergence) apiExpand(r *http.Request) ([]string, string) {
+	var expand []string
+
+	for _, value := range r.URL.Query()["expand"] {
+		for _, name := range splitList(value) {
+			allowed := false
+			for _, candidate := range c.APIExpand {
+				if candidate == name {
+					allowed = true
+					break
+				}
+			}
+
+			if !allowed {
+				return nil, name
+			}
+
+			if !hasExpand(expand, name) {
+				expand = append(expand, name)
+			}
+		}
+	}
+
+	// the same expansions share a cache entry
+	sort.Strings(expand)
+
+	return expand, ""
+}
+
+func (c *Convergence) apiSpaces(w http.ResponseWriter, r *http.Request) {
+	spaces, err := c.confluence.GetSpaces(r.Context())
+	if err != nil {
+		c.showAPIError(w, r, err)
+		return
+	}
+
+	// hide spaces that are not exposed
+	allowed := make([]*Space, 0, len(spaces))
+	for _, space := range spaces {
+		if c.spaceAllowed(space.Key) {
+			allowed = append(allowed, space)
+		}
+	}
+
+	c.render.JSON(w, http.StatusOK, allowed)
+}
+
+func (c *Convergence) apiSpace(w http.ResponseWriter, r *http.Request) {
+	key := c.spaceKey(chi.URLParam(r, "key"))
+
+	if !c.spaceAllowed(key) {
+		c.showAPIError(w, r, ErrNotFound)
+		return
+	}
+
+	space, err := c.confluence.GetSpace(r.Context(), key)
+	if err != nil {
+		c.showAPIError(w, r, err)
+		return
+	}
+
+	c.render.JSON(w, http.StatusOK, space)
+}
+
+func (c *Convergence) apiPage(w http.ResponseWriter, r *http.Request) {
+	key := c.spaceKey(chi.URLParam(r, "key"))
+	id := chi.URLParam(r, "id")
+
+	if !c.spaceAllowed(key) {
+		c.showAPIError(w, r, ErrNotFound)
+		return
+	}
+
+	expand, rejected := c.apiExpand(r)
+	if rejected != "" {
+		c.render.JSON(w, http.StatusBadRequest, map[string]string{
+			"error": "unsupported expansion: " + rejected,
+		})
+		return
+	}
+
+	var page *Page
+	var err error
+
+	// lookup by id or title
+	if _, convErr := strconv.Atoi(id); convErr == nil {
+		page, err = c.confluence.GetPageByID(r.Context(), key, id, expand...)
+	} else {
+		page, err = c.confluence.GetPageByTitle(r.Context(), key, decodeTitle(id), expand...)
+	}
+
+	if err == nil && !c.spaceAllowed(page.SpaceKey) {
+		err = ErrNotFound
+	}
+
+	if err != nil {
+		c.showAPIError(w, r, err)
+		return
+	}
+
+	c.render.JSON(w, http.StatusOK, struct {
+		*Page
+		Markdown  string `json:"markdown,omitempty"`
+		PlainText string `json:"plainText,omitempty"`
+	}{page, c.markdown(page.Body), toPlainText(page.Body)})
+}
+
+func (c *Convergence) showAPIError(w http.ResponseWriter, r *http.Request, err error) {
+	setRetryAfter(w, err)
+
+	c.render.JSON(w, errorStatus(err), map[string]string{
+		"error": err.Error(),
+	})
+}
+
+func (c *Convergence) corsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+
+		// only the api is shared with other origins
+		if !strings.HasPrefix(r.URL.Path, "/api/") || origin == "" || len(c.CORSOrigins) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		allowed := false
+		for _, candidate := range c.CORSOrigins {
+			if candidate == "*" || strings.EqualFold(candidate, origin) {
+				allowed = true
+				break
+			}
+		}
+
+		// echo the origin as responses differ per origin
+		if allowed {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Add("Vary", "Origin")
+		}
+
+		// answer preflight requests directly
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			if !allowed {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(c.CORSMethods, ", "))
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(c.CORSHeaders, ", "))
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+type budgetEntry struct {
+	key     string
+	size    int64
+	expires time.Time
+}
+
+type attachmentBudget struct {
+	mutex   sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+	used    int64
+}
+
+func (b *attachmentBudget) add(key string, size, limit int64, ttl time.Duration) []string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.entries == nil {
+		b.order = list.New()
+		b.entries = make(map[string]*list.Element)
+	}
+
+	// replace existing entry
+	b.remove(key)
+
+	now := time.Now()
+
+	b.entries[key] = b.order.PushFront(&budgetEntry{key: key, size: size, expires: now.Add(ttl)})
+	b.used += size
+
+	// expired entries no longer take up space
+	if limit > 0 && b.used > limit {
+		for k, element := range b.entries {
+			if now.After(element.Value.(*budgetEntry).expires) {
+				b.remove(k)
+			}
+		}
+	}
+
+	// drop least recently used entries beyond the limit
+	var evicted []string
+	for limit > 0 && b.used > limit && b.order.Len() > 0 {
+		entry := b.order.Back().Value.(*budgetEntry)
+		b.remove(entry.key)
+		evicted = append(evicted, entry.key)
+	}
+
+	attachmentCacheBytes.Set(float64(b.used))
+
+	return evicted
+}
+
+func (b *attachmentBudget) touch(key string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if element, ok := b.entries[key]; ok {
+		b.order.MoveToFront(element)
+	}
+}
+
+func (b *attachmentBudget) forget(key string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.remove(key)
+	attachmentCacheBytes.Set(float64(b.used))
+}
+
+func (b *attachmentBudget) reset() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.order = nil
+	b.entries = nil
+	b.used = 0
+	attachmentCacheBytes.Set(0)
+}
+
+func (b *attachmentBudget) remove(key string) {
+	if element, ok := b.entries[key]; ok {
+		b.used -= element.Value.(*budgetEntry).size
+		b.order.Remove(element)
+		delete(b.entries, key)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAttachmentBudget(t *testing.T) {
+	tests := []struct {
+		key     string
+		size    int64
+		ttl     time.Duration
+		wait    time.Duration
+		evicted []string
+		used    int64
+	}{
+		{"a", 40, time.Hour, 0, nil, 40},
+		{"b", 40, 10 * time.Millisecond, 0, nil, 80},
+		// b expired and is released instead of evicting a
+		{"c", 40, time.Hour, 20 * time.Millisecond, nil, 80},
+		// live entries are still evicted least recently used first
+		{"d", 40, time.Hour, 0, []string{"a"}, 80},
+	}
+
+	var budget attachmentBudget
+
+	for _, test := range tests {
+		time.Sleep(test.wait)
+
+		evicted := budget.add(test.key, test.size, 100, test.ttl)
+		if !reflect.DeepEqual(evicted, test.evicted) {
+			t.Errorf("add(%q) evicted %v; want %v", test.key, evicted, test.evicted)
+		}
+		if budget.used != test.used {
+			t.Errorf("add(%q) used %d bytes; want %d", test.key, budget.used, test.used)
+		}
+	}
+}
+
+func TestAttachmentBudgetRelease(t *testing.T) {
+	confluence := newTestConfluence(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(strings.Repeat("x", 40)))
+	})
+
+	confluence.Cache = NewPrefixedCache(NewMemoryCache(0), "test:")
+	confluence.AttachmentCacheSize = 100
+	confluence.AttachmentsTTL = time.Hour
+
+	tests := []struct {
+		name    string
+		release func()
+	}{
+		{"delete", func() {
+			confluence.cache().Delete("attachment-1-a.txt---")
+		}},
+		{"reset", func() {
+			confluence.Reset()
+		}},
+	}
+
+	for _, test := range tests {
+		if _, err := confluence.GetAttachment(context.Background(), "1", "a.txt", "", "", ""); err != nil {
+			t.Fatal(err)
+		}
+		if confluence.attachments.used != 40 {
+			t.Fatalf("%s: used %d bytes before release; want 40", test.name, confluence.attachments.used)
+		}
+
+		test.release()
+
+		if confluence.attachments.used != 0 {
+			t.Errorf("%s: used %d bytes after release; want 0", test.name, confluence.attachments.used)
+		}
+	}
+}
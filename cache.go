@@ -0,0 +1,148 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+)
+
+type Cache interface {
+	Get(key string) (interface{}, bool)
+	Set(key string, value interface{}, ttl time.Duration)
+	Delete(key string)
+	Keys(prefix string) []string
+	Flush()
+}
+
+// caches may report the remaining lifetime of entries
+type expiringCache interface {
+	TTL(key string) (time.Duration, bool)
+}
+
+// caches may report entries they drop on their own
+type evictingCache interface {
+	OnEvicted(fn func(key string))
+}
+
+type memoryCache struct {
+	cache *cache.Cache
+}
+
+func NewMemoryCache(cleanup time.Duration) Cache {
+	return &memoryCache{
+		cache: cache.New(cache.NoExpiration, cleanup),
+	}
+}
+
+func (m *memoryCache) Get(key string) (interface{}, bool) {
+	return m.cache.Get(key)
+}
+
+func (m *memoryCache) Set(key string, value interface{}, ttl time.Duration) {
+	m.cache.Set(key, value, ttl)
+}
+
+func (m *memoryCache) Delete(key string) {
+	m.cache.Delete(key)
+}
+
+func (m *memoryCache) Keys(prefix string) []string {
+	var keys []string
+	for key := range m.cache.Items() {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+func (m *memoryCache) Flush() {
+	m.cache.Flush()
+}
+
+func (m *memoryCache) OnEvicted(fn func(key string)) {
+	m.cache.OnEvicted(func(key string, value interface{}) {
+		fn(key)
+	})
+}
+
+func (m *memoryCache) TTL(key string) (time.Duration, bool) {
+	_, expiration, ok := m.cache.GetWithExpiration(key)
+	if !ok || expiration.IsZero() {
+		return 0, false
+	}
+
+	return time.Until(expiration), true
+}
+
+type prefixedCache struct {
+	cache  Cache
+	prefix string
+}
+
+func NewPrefixedCache(cache Cache, prefix string) Cache {
+	return &prefixedCache{
+		cache:  cache,
+		prefix: prefix,
+	}
+}
+
+func (p *prefixedCache) Get(key string) (interface{}, bool) {
+	return p.cache.Get(p.prefix + key)
+}
+
+func (p *prefixedCache) Set(key string, value interface{}, ttl time.Duration) {
+	p.cache.Set(p.prefix+key, value, ttl)
+}
+
+func (p *prefixedCache) Delete(key string) {
+	p.cache.Delete(p.prefix + key)
+}
+
+func (p *prefixedCache) Keys(prefix string) []string {
+	var keys []string
+	for _, key := range p.cache.Keys(p.prefix + prefix) {
+		keys = append(keys, strings.TrimPrefix(key, p.prefix))
+	}
+
+	return keys
+}
+
+func (p *prefixedCache) Flush() {
+	// leave entries of other instances alone
+	for _, key := range p.Keys("") {
+		p.Delete(key)
+	}
+}
+
+func (p *prefixedCache) OnEvicted(fn func(key string)) {
+	if cache, ok := p.cache.(evictingCache); ok {
+		cache.OnEvicted(func(key string) {
+			// ignore entries of other instances
+			if strings.HasPrefix(key, p.prefix) {
+				fn(strings.TrimPrefix(key, p.prefix))
+			}
+		})
+	}
+}
+
+func (p *prefixedCache) TTL(key string) (time.Duration, bool) {
+	if cache, ok := p.cache.(expiringCache); ok {
+		return cache.TTL(p.prefix + key)
+	}
+
+	return 0, false
+}
+
+func CachePrefix(baseURL string) string {
+	// distinguish instances by the confluence they serve
+	u, err := url.Parse(baseURL)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+
+	return u.Host + strings.TrimRight(u.Path, "/") + ":"
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+func (c *Confluence) acquire(ctx context.Context) error {
+	// wait for a free slot if limited
+	if c.MaxConcurrency > 0 {
+		c.slotsOnce.Do(func() {
+			c.slots = make(chan struct{}, c.MaxConcurrency)
+		})
+
+		select {
+		case c.slots <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	upstreamInFlight.Inc()
+
+	return nil
+}
+
+func (c *Confluence) release() {
+	upstreamInFlight.Dec()
+
+	if c.slots != nil {
+		<-c.slots
+	}
+}
+
+type releaseBody struct {
+	io.ReadCloser
+
+	once    sync.Once
+	release func()
+}
+
+func (b *releaseBody) Close() error {
+	// streamed bodies hold their slot until closed
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
@@ -1,29 +1,93 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"html"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Jeffail/gabs"
 	"github.com/microcosm-cc/bluemonday"
 	"github.com/parnurzeal/gorequest"
-	"github.com/patrickmn/go-cache"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+	"golang.org/x/sync/singleflight"
 )
 
 type Space struct {
-	Key         string
-	Name        string
-	Description string
-	Homepage    Page
+	Key         string    `json:"key"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Homepage    Page      `json:"homepage"`
+	FetchedAt   time.Time `json:"fetchedAt"`
 }
 
 type Page struct {
-	ID    string
-	Title string
-	Body  string
+	ID       string `json:"id"`
+	Type     string `json:"type,omitempty"`
+	Title    string `json:"title"`
+	Body     string `json:"body,omitempty"`
+	Storage  string `json:"storage,omitempty"`
+	SpaceKey string `json:"spaceKey,omitempty"`
+	Excerpt  string `json:"excerpt,omitempty"`
+	Link     string `json:"link,omitempty"`
+
+	Labels []string `json:"labels,omitempty"`
+
+	Version     int       `json:"version,omitempty"`
+	UpdatedBy   string    `json:"updatedBy,omitempty"`
+	UpdatedByID string    `json:"updatedById,omitempty"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+	FetchedAt   time.Time `json:"fetchedAt"`
+
+	Ancestors []*Page `json:"ancestors,omitempty"`
+
+	TOC []TOCEntry `json:"toc,omitempty"`
+}
+
+type PageList struct {
+	Pages []*Page `json:"pages"`
+	More  bool    `json:"more"`
+}
+
+type User struct {
+	AccountID      string `json:"accountId"`
+	DisplayName    string `json:"displayName"`
+	Email          string `json:"email,omitempty"`
+	ProfilePicture string `json:"profilePicture,omitempty"`
+}
+
+type Comment struct {
+	ID        string    `json:"id"`
+	Author    string    `json:"author,omitempty"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type AttachmentMeta struct {
+	ID        string `json:"id"`
+	Filename  string `json:"filename"`
+	MediaType string `json:"mediaType,omitempty"`
+	Size      int64  `json:"size"`
+	Version   int    `json:"version,omitempty"`
+	Link      string `json:"link"`
+}
+
+type Attachment struct {
+	ContentType string
+	Data        []byte
 }
 
 type Response struct {
@@ -32,274 +96,2293 @@ type Response struct {
 	Header map[string][]string
 }
 
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+type StatusError struct {
+	Code int
+}
+
+func (e StatusError) Error() string {
+	return "unexpected status: " + strconv.Itoa(e.Code)
+}
+
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e RateLimitError) Error() string {
+	return "rate limited, retry after " + e.RetryAfter.String()
+}
+
+type RequestError struct {
+	Err error
+}
+
+func (e RequestError) Error() string {
+	return "request failed: " + e.Err.Error()
+}
+
+func (e RequestError) Unwrap() error {
+	return e.Err
+}
+
+type ParseError struct {
+	Err error
+}
+
+func (e ParseError) Error() string {
+	return "invalid response: " + e.Err.Error()
+}
+
+func (e ParseError) Unwrap() error {
+	return e.Err
+}
+
+type UnexpectedResponseError struct {
+	Status      int
+	ContentType string
+}
+
+func (e UnexpectedResponseError) Error() string {
+	kind := e.ContentType
+	if kind == "" {
+		kind = "non-json"
+	}
+
+	// login pages are the usual cause
+	return "unexpected " + kind + " response with status " + strconv.Itoa(e.Status) + ", check the credentials"
+}
+
+func (e UnexpectedResponseError) Unwrap() error {
+	return ErrUnexpectedResponse
+}
+
+type CacheTrace struct {
+	Hits   int32
+	Misses int32
+	Stale  int32
+}
+
+func (t *CacheTrace) Cached() bool {
+	return atomic.LoadInt32(&t.Hits) > 0 && atomic.LoadInt32(&t.Misses) == 0
+}
+
+func (t *CacheTrace) ServedStale() bool {
+	return atomic.LoadInt32(&t.Stale) > 0
+}
+
+type cacheTraceKey struct{}
+
+type notFoundEntry struct{}
+
+type staleEntry struct {
+	Value   interface{}
+	Expires time.Time
+}
+
+func WithCacheTrace(ctx context.Context) (context.Context, *CacheTrace) {
+	trace := &CacheTrace{}
+	return context.WithValue(ctx, cacheTraceKey{}, trace), trace
+}
+
 var ErrNotFound = errors.New("not found")
+var ErrUnauthorized = errors.New("unauthorized")
+var ErrForbidden = errors.New("forbidden")
+var ErrTooLarge = errors.New("too large")
+var ErrEmptyResponse = errors.New("zero response")
+var ErrUnexpectedResponse = errors.New("unexpected response")
+
+const responseCacheTTL = 24 * time.Hour
+
+const blogPostLimit = 25
 
 type Confluence struct {
+	APIVersion  string
+	PageSize    int
+	SpaceType   string
+	ContextPath string
+	Token       string
+	Timeout     time.Duration
+	Logger      Logger
+
+	TracerProvider trace.TracerProvider
+
+	OnRequest func(method, url string, duration time.Duration, status int, err error)
+
+	OAuthClientID     string
+	OAuthClientSecret string
+	OAuthTokenURL     string
+	OAuthScopes       []string
+
+	SpaceCredentials map[string]Credentials
+
+	CacheTTL     time.Duration
+	CacheCleanup time.Duration
+	RecentTTL    time.Duration
+	StaleTTL     time.Duration
+
+	StaleIfErrorTTL time.Duration
+
+	SpacesTTL      time.Duration
+	PagesTTL       time.Duration
+	AttachmentsTTL time.Duration
+
+	NegativeCacheTTL time.Duration
+
+	FetchStorage bool
+	PageExpand   []string
+	SpaceExpand  []string
+	TOCDepth     int
+
+	MaxRetries   int
+	RetryBackoff time.Duration
+
+	MaxConcurrency int
+
+	WarmDepth       int
+	WarmConcurrency int
+
+	Transport *http.Transport
+	Cache     Cache
+
+	MaxAttachmentSize   int64
+	AttachmentCacheSize int64
+
+	Sanitizer *bluemonday.Policy
+
 	baseURL  string
 	username string
 	password string
 
-	contentCache  *cache.Cache
-	responseCache *cache.Cache
-	client        *gorequest.SuperAgent
-	sanitizer     *bluemonday.Policy
+	tokenMutex  sync.Mutex
+	tokenSource oauth2.TokenSource
+
+	group      singleflight.Group
+	refreshing sync.Map
+
+	cacheOnce sync.Once
+	evictOnce sync.Once
+
+	slotsOnce sync.Once
+	slots     chan struct{}
+
+	attachments attachmentBudget
+	pageSpaces  sync.Map
+	spaceKeys   sync.Map
 }
 
 func NewConfluence(baseURL, username, password string) *Confluence {
 	c := &Confluence{
-		baseURL:   strings.TrimSuffix(baseURL, "/"),
-		username:  username,
-		password:  password,
-		client:    gorequest.New(),
-		sanitizer: bluemonday.UGCPolicy(),
-	}
+		APIVersion:  "v1",
+		PageSize:    100,
+		SpaceType:   "global",
+		ContextPath: "/wiki",
+		Timeout:     30 * time.Second,
+
+		CacheTTL:     30 * time.Minute,
+		CacheCleanup: time.Minute,
+		RecentTTL:    5 * time.Minute,
+
+		NegativeCacheTTL: time.Minute,
+
+		MaxAttachmentSize: 10 << 20,
+
+		Sanitizer: NewSanitizer(),
+
+		RetryBackoff: 500 * time.Millisecond,
 
-	c.sanitizer.RequireNoFollowOnLinks(false)
-	c.sanitizer.RequireNoFollowOnFullyQualifiedLinks(true)
-	c.sanitizer.AllowAttrs("class").Globally()
+		PageExpand:  []string{"body.view", "space", "ancestors", "version"},
+		SpaceExpand: []string{"description.view", "homepage.body.view"},
+		TOCDepth:    3,
 
-	c.Reset()
+		WarmConcurrency: 4,
+
+		baseURL:  normalizeBaseURL(baseURL),
+		username: username,
+		password: password,
+	}
 
 	return c
 }
 
-func (c *Confluence) url(path string) string {
-	return c.baseURL + "/wiki/rest/api/" + path
+func NewSanitizer() *bluemonday.Policy {
+	// keep confluence formatting but strip scripts and event handlers
+	policy := bluemonday.UGCPolicy()
+	policy.RequireNoFollowOnLinks(false)
+	policy.RequireNoFollowOnFullyQualifiedLinks(true)
+	policy.AllowAttrs("class").Globally()
+	policy.AllowDataAttributes()
+
+	return policy
 }
 
-func (c *Confluence) GetSpaces() ([]*Space, error) {
-	cacheKey := "spaces"
+type ConfluenceConfig struct {
+	BaseURL     string
+	Flavor      string
+	APIVersion  string
+	ContextPath string
+	Username    string
+	Password    string
+	Token       string
+
+	OAuthClientID     string
+	OAuthClientSecret string
+	OAuthTokenURL     string
+	OAuthScopes       []string
+}
 
-	if value, ok := c.contentCache.Get(cacheKey); ok {
-		return value.([]*Space), nil
+func ConfluenceConfigFromEnv() ConfluenceConfig {
+	config := ConfluenceConfig{
+		BaseURL:     getEnv("CONFLUENCE_BASE_URL", "BASE_URL"),
+		Flavor:      os.Getenv("CONFLUENCE_FLAVOR"),
+		APIVersion:  os.Getenv("CONFLUENCE_API_VERSION"),
+		ContextPath: os.Getenv("CONFLUENCE_CONTEXT_PATH"),
+		Username:    getEnv("CONFLUENCE_USERNAME", "USERNAME"),
+		Password:    getEnv("CONFLUENCE_PASSWORD", "PASSWORD"),
+		Token:       getEnv("CONFLUENCE_TOKEN", "TOKEN"),
+
+		OAuthClientID:     os.Getenv("CONFLUENCE_OAUTH_CLIENT_ID"),
+		OAuthClientSecret: os.Getenv("CONFLUENCE_OAUTH_CLIENT_SECRET"),
+		OAuthTokenURL:     os.Getenv("CONFLUENCE_OAUTH_TOKEN_URL"),
 	}
 
-	_, res, errs := c.client.Get(c.url("space")).
-		Set("Accept", "application/json, */*").
-		Query("expand=description.view,homepage.body.view").
-		Query("limit=256").
-		SetBasicAuth(c.username, c.password).
-		End()
+	if scopes := os.Getenv("CONFLUENCE_OAUTH_SCOPES"); scopes != "" {
+		config.OAuthScopes = strings.Fields(strings.Replace(scopes, ",", " ", -1))
+	}
 
-	if len(errs) > 0 {
-		return nil, errs[0]
+	return config
+}
+
+func (config ConfluenceConfig) Validate() error {
+	// collect missing settings
+	var missing []string
+	if config.BaseURL == "" {
+		missing = append(missing, "CONFLUENCE_BASE_URL")
+	}
+	if config.OAuthClientID != "" {
+		if config.OAuthClientSecret == "" {
+			missing = append(missing, "CONFLUENCE_OAUTH_CLIENT_SECRET")
+		}
+		if config.OAuthTokenURL == "" {
+			missing = append(missing, "CONFLUENCE_OAUTH_TOKEN_URL")
+		}
+	} else {
+		if config.Token == "" && config.Username == "" {
+			missing = append(missing, "CONFLUENCE_USERNAME")
+		}
+		if config.Token == "" && config.Password == "" {
+			missing = append(missing, "CONFLUENCE_PASSWORD")
+		}
 	}
 
-	if len(res) == 0 {
-		return nil, errors.New("zero response")
+	if len(missing) > 0 {
+		return errors.New("missing configuration: " + strings.Join(missing, ", "))
 	}
 
-	json, err := gabs.ParseJSON([]byte(res))
-	if err != nil {
-		return nil, err
+	// validate base url
+	if u, err := url.Parse(config.BaseURL); err != nil || u.Scheme == "" || u.Host == "" {
+		return errors.New("invalid base url: " + config.BaseURL)
 	}
 
-	array, err := json.Path("results").Children()
-	if err != nil {
+	// validate flavor
+	if config.Flavor != "" && config.Flavor != "cloud" && config.Flavor != "server" {
+		return errors.New("invalid flavor: " + config.Flavor)
+	}
+
+	// validate api version, v2 is only offered by cloud
+	if config.APIVersion != "" && config.APIVersion != "v1" && config.APIVersion != "v2" {
+		return errors.New("invalid api version: " + config.APIVersion)
+	}
+	if config.APIVersion == "v2" && config.Flavor == "server" {
+		return errors.New("api version v2 requires cloud")
+	}
+
+	return nil
+}
+
+func NewConfluenceFromConfig(config ConfluenceConfig) (*Confluence, error) {
+	if err := config.Validate(); err != nil {
 		return nil, err
 	}
 
-	spaces := make([]*Space, len(array))
+	c := NewConfluence(config.BaseURL, config.Username, config.Password)
+	c.Token = config.Token
+
+	if config.APIVersion != "" {
+		c.APIVersion = config.APIVersion
+	}
+
+	// server installations are served from the root by default
+	if config.Flavor == "server" {
+		c.ContextPath = ""
+	}
+	if config.ContextPath != "" {
+		c.ContextPath = "/" + strings.Trim(config.ContextPath, "/")
+	}
+
+	c.OAuthClientID = config.OAuthClientID
+	c.OAuthClientSecret = config.OAuthClientSecret
+	c.OAuthTokenURL = config.OAuthTokenURL
+	c.OAuthScopes = config.OAuthScopes
+
+	return c, nil
+}
 
-	for i, obj := range array {
-		space := &Space{}
+func NewConfluenceFromEnv() (*Confluence, error) {
+	return NewConfluenceFromConfig(ConfluenceConfigFromEnv())
+}
 
-		space.Key = obj.Path("key").Data().(string)
-		space.Name = obj.Path("name").Data().(string)
-		space.Description = obj.Path("description.view.value").Data().(string)
-		space.Homepage = Page{
-			ID:    obj.Path("homepage.id").Data().(string),
-			Title: obj.Path("homepage.title").Data().(string),
-			Body:  c.processBody(obj.Path("homepage.body.view.value").Data().(string)),
+func getEnv(names ...string) string {
+	// return first non empty variable
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
 		}
+	}
+
+	return ""
+}
+
+func normalizeBaseURL(baseURL string) string {
+	// store without trailing slashes, paths are always appended with one
+	return strings.TrimRight(strings.TrimSpace(baseURL), "/")
+}
+
+func (c *Confluence) endpoint(path string) string {
+	// all paths are relative to the context path
+	return c.baseURL + c.ContextPath + "/" + strings.TrimPrefix(path, "/")
+}
+
+func (c *Confluence) url(path string) string {
+	return c.endpoint("rest/api/" + strings.TrimPrefix(path, "/"))
+}
 
-		spaces[i] = space
+func (c *Confluence) downloadURL(path string) string {
+	return c.endpoint("download/" + strings.TrimPrefix(path, "/"))
+}
+
+func (c *Confluence) GetSpaces(ctx context.Context) ([]*Space, error) {
+	cacheKey := "spaces-all"
+
+	if value, ok := c.cachedFresh(cacheKey, func(ctx context.Context) error {
+		_, err := c.fetchSpaces(ctx, cacheKey)
+		return err
+	}); ok {
+		c.cacheHit(ctx, cacheKey)
+		return value.([]*Space), nil
 	}
 
-	c.contentCache.Set(cacheKey, spaces, cache.DefaultExpiration)
+	c.cacheMiss(ctx, cacheKey)
 
-	return spaces, nil
+	// concurrent misses share a single request
+	value, err := c.shared(ctx, cacheKey, func(ctx context.Context) (interface{}, error) {
+		return c.fetchSpaces(ctx, cacheKey)
+	})
+	if err != nil {
+		if value, ok := c.cachedStale(ctx, cacheKey, err); ok {
+			return value.([]*Space), nil
+		}
+
+		return nil, err
+	}
+
+	return value.([]*Space), nil
 }
 
-func (c *Confluence) GetSpace(key string) (*Space, error) {
-	spaces, err := c.GetSpaces()
+func (c *Confluence) fetchSpaces(ctx context.Context, cacheKey string) ([]*Space, error) {
+	var spaces []*Space
+	var err error
+
+	if c.v2() {
+		spaces, err = c.fetchSpacesV2(ctx)
+	} else {
+		spaces, err = c.fetchSpacesV1(ctx)
+	}
 	if err != nil {
 		return nil, err
 	}
 
+	// remember when the list was synced
+	now := time.Now()
 	for _, space := range spaces {
-		if space.Key == key {
-			return space, nil
-		}
+		space.FetchedAt = now
 	}
 
-	return nil, ErrNotFound
+	c.cacheFresh(cacheKey, spaces)
+
+	return spaces, nil
 }
 
-func (c *Confluence) GetPageByID(key, id string) (*Page, error) {
-	cacheKey := "page-" + key + "-" + id
+func (c *Confluence) fetchSpacesV1(ctx context.Context) ([]*Space, error) {
+	var spaces []*Space
 
-	if value, ok := c.contentCache.Get(cacheKey); ok {
-		return value.(*Page), nil
+	for start := 0; ; {
+		agent := c.agent().Get(c.url("space")).
+			Set("Accept", "application/json, */*").
+			Query("expand=" + strings.Join(c.SpaceExpand, ",")).
+			Query("start=" + strconv.Itoa(start)).
+			Query("limit=" + strconv.Itoa(c.PageSize))
+
+		// filter by space type
+		if c.SpaceType != "" {
+			agent.Query("type=" + url.QueryEscape(c.SpaceType))
+		}
+
+		_, res, err := c.end(ctx, agent)
+		if err != nil {
+			return nil, err
+		}
+
+		json, err := parseJSON(res)
+		if err != nil {
+			return nil, err
+		}
+
+		array, err := json.Path("results").Children()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, obj := range array {
+			space, err := c.parseSpace(obj)
+			if err != nil {
+				return nil, err
+			}
+
+			spaces = append(spaces, space)
+		}
+
+		// stop when there is no next page
+		if len(array) == 0 || json.Path("_links.next").Data() == nil {
+			break
+		}
+
+		start += len(array)
 	}
 
-	_, res, errs := c.client.Get(c.url("content/"+id)).
-		Set("Accept", "application/json, */*").
-		Query("type=page").
-		Query("spaceKey="+key).
-		Query("expand=body.view").
-		SetBasicAuth(c.username, c.password).
-		End()
+	return spaces, nil
+}
 
-	if len(errs) > 0 {
-		return nil, errs[0]
+func (c *Confluence) GetSpace(ctx context.Context, key string) (*Space, error) {
+	cacheKey := "space-" + key
+
+	if value, ok := c.cachedFresh(cacheKey, func(ctx context.Context) error {
+		_, err := c.fetchSpace(ctx, key, cacheKey)
+		return err
+	}); ok {
+		c.cacheHit(ctx, cacheKey)
+		if _, ok := value.(notFoundEntry); ok {
+			return nil, ErrNotFound
+		}
+
+		return value.(*Space), nil
 	}
 
-	if len(res) == 0 {
-		return nil, errors.New("zero response")
+	// use full list if already available, v2 lists lack homepage bodies
+	if value, ok := c.cachedFresh("spaces-all", nil); ok && !c.v2() {
+		for _, space := range value.([]*Space) {
+			if space.Key == key {
+				return space, nil
+			}
+		}
 	}
 
-	obj, err := gabs.ParseJSON([]byte(res))
+	c.cacheMiss(ctx, cacheKey)
+
+	// concurrent misses share a single request
+	value, err := c.shared(ctx, cacheKey, func(ctx context.Context) (interface{}, error) {
+		return c.fetchSpace(ctx, key, cacheKey)
+	})
 	if err != nil {
+		if value, ok := c.cachedStale(ctx, cacheKey, err); ok {
+			return value.(*Space), nil
+		}
+
 		return nil, err
 	}
 
-	page := &Page{}
+	return value.(*Space), nil
+}
 
-	page.ID = obj.Path("id").Data().(string)
-	page.Title = obj.Path("title").Data().(string)
-	page.Body = c.processBody(obj.Path("body.view.value").Data().(string))
+func (c *Confluence) fetchSpace(ctx context.Context, key, cacheKey string) (*Space, error) {
+	ctx = withSpace(ctx, key)
 
-	c.contentCache.Set(cacheKey, page, cache.DefaultExpiration)
+	var space *Space
+	var err error
 
-	return page, nil
-}
+	if c.v2() {
+		space, err = c.fetchSpaceV2(ctx, key)
+	} else {
+		space, err = c.fetchSpaceV1(ctx, key)
+	}
+	if err != nil {
+		c.cacheNotFound(cacheKey, err)
+		return nil, err
+	}
 
-func (c *Confluence) GetPageByTitle(key, title string) (*Page, error) {
-	cacheKey := "page-" + key + "-" + title
+	space.FetchedAt = time.Now()
 
-	if value, ok := c.contentCache.Get(cacheKey); ok {
-		return value.(*Page), nil
-	}
+	c.cacheFresh(cacheKey, space)
+
+	return space, nil
+}
 
-	_, res, errs := c.client.Get(c.url("content")).
+func (c *Confluence) fetchSpaceV1(ctx context.Context, key string) (*Space, error) {
+	_, data, err := c.end(ctx, c.agent().Get(c.url("space/"+url.PathEscape(key))).
 		Set("Accept", "application/json, */*").
-		Query("title="+title).
-		Query("type=page").
-		Query("spaceKey="+key).
-		Query("expand=body.view").
-		SetBasicAuth(c.username, c.password).
-		End()
+		Query("expand="+strings.Join(c.SpaceExpand, ",")))
+	if err != nil {
+		return nil, err
+	}
 
-	if len(errs) > 0 {
-		return nil, errs[0]
+	obj, err := parseJSON(data)
+	if err != nil {
+		return nil, err
 	}
 
-	if len(res) == 0 {
-		return nil, errors.New("zero response")
+	return c.parseSpace(obj)
+}
+
+func (c *Confluence) GetPageByID(ctx context.Context, key, id string, expand ...string) (*Page, error) {
+	return c.getContent(ctx, "page", key, id, expand)
+}
+
+func (c *Confluence) GetBlogPostByID(ctx context.Context, key, id string, expand ...string) (*Page, error) {
+	return c.getContent(ctx, "blogpost", key, id, expand)
+}
+
+func (c *Confluence) getContent(ctx context.Context, kind, key, id string, expand []string) (*Page, error) {
+	cacheKey := kind + "-" + key + "-" + id + expandSuffix(expand)
+
+	if value, ok := c.cachedFresh(cacheKey, func(ctx context.Context) error {
+		_, err := c.fetchContent(ctx, kind, key, id, expand, cacheKey)
+		return err
+	}); ok {
+		c.cacheHit(ctx, cacheKey)
+		if _, ok := value.(notFoundEntry); ok {
+			return nil, ErrNotFound
+		}
+
+		return value.(*Page), nil
 	}
 
-	json, err := gabs.ParseJSON([]byte(res))
+	c.cacheMiss(ctx, cacheKey)
+
+	// concurrent misses share a single request
+	value, err := c.shared(ctx, cacheKey, func(ctx context.Context) (interface{}, error) {
+		return c.fetchContent(ctx, kind, key, id, expand, cacheKey)
+	})
 	if err != nil {
+		if value, ok := c.cachedStale(ctx, cacheKey, err); ok {
+			return value.(*Page), nil
+		}
+
 		return nil, err
 	}
 
-	results, err := json.Path("results").Children()
+	return value.(*Page), nil
+}
+
+func (c *Confluence) fetchContent(ctx context.Context, kind, key, id string, expand []string, cacheKey string) (*Page, error) {
+	ctx = withSpace(ctx, key)
+
+	var page *Page
+	var err error
+
+	// skip the body if the cached version is still current
+	if cached, ok := c.currentPage(ctx, kind, key, cacheKey); ok {
+		copied := *cached
+		page = &copied
+	} else if c.v2() {
+		page, err = c.fetchContentV2(ctx, kind, id, expand)
+	} else {
+		page, err = c.fetchContentV1(ctx, kind, key, id, expand)
+	}
 	if err != nil {
+		c.cacheNotFound(cacheKey, err)
 		return nil, err
 	}
 
-	if len(results) == 0 {
+	// ids are shared across content types
+	if page.Type != "" && page.Type != kind {
+		c.cacheNotFound(cacheKey, ErrNotFound)
 		return nil, ErrNotFound
 	}
 
-	obj := results[0]
-	page := &Page{}
+	page.FetchedAt = time.Now()
 
-	page.ID = obj.Path("id").Data().(string)
-	page.Title = obj.Path("title").Data().(string)
-	page.Body = c.processBody(obj.Path("body.view.value").Data().(string))
+	c.cacheFresh(cacheKey, page)
 
-	c.contentCache.Set(cacheKey, page, cache.DefaultExpiration)
+	// allow later lookups by title
+	if kind == "page" && (page.SpaceKey == "" || page.SpaceKey == key) {
+		c.cacheFresh(titleCacheKey(key, page.Title)+expandSuffix(expand), page)
+	}
 
 	return page, nil
 }
 
-func (c *Confluence) GetResponse(r *http.Request) (*Response, error) {
-	// check cache
-	if value, ok := c.responseCache.Get(r.URL.RequestURI()); ok {
-		return value.(*Response), nil
+func (c *Confluence) fetchContentV1(ctx context.Context, kind, key, id string, expand []string) (*Page, error) {
+	_, res, err := c.end(ctx, c.agent().Get(c.url("content/"+id)).
+		Set("Accept", "application/json, */*").
+		Query("type="+kind).
+		Query("spaceKey="+url.QueryEscape(key)).
+		Query("expand="+c.pageExpand(expand)))
+	if err != nil {
+		return nil, err
 	}
 
-	// make new request
-	r2, err := http.NewRequest("GET", c.baseURL+r.URL.RequestURI(), r.Body)
+	obj, err := parseJSON(res)
 	if err != nil {
 		return nil, err
 	}
 
-	// add authentication
-	r2.SetBasicAuth(c.username, c.password)
+	return c.parsePage(obj)
+}
 
-	// make request
-	res, err := http.DefaultClient.Do(r2)
+func (c *Confluence) GetBlogPosts(ctx context.Context, key string) ([]*Page, error) {
+	ctx = withSpace(ctx, key)
+
+	cacheKey := "blogposts-" + key
+
+	if value, ok := c.cache().Get(cacheKey); ok {
+		c.cacheHit(ctx, cacheKey)
+		return value.([]*Page), nil
+	}
+
+	c.cacheMiss(ctx, cacheKey)
+
+	// newest posts first
+	cql := "type = blogpost and space = " + quoteCQL(key) + " order by created desc"
+
+	posts, err := c.getPages(ctx, c.url("content/search"), blogPostLimit,
+		"cql="+url.QueryEscape(cql),
+		"expand=space,version")
 	if err != nil {
 		return nil, err
 	}
 
-	// read full body
-	buf, err := ioutil.ReadAll(res.Body)
+	c.cacheContent(cacheKey, posts)
+
+	return posts, nil
+}
+
+func (c *Confluence) GetRecentPages(ctx context.Context, key string, limit int) ([]*Page, error) {
+	ctx = withSpace(ctx, key)
+
+	cacheKey := "recent-" + key + "-" + strconv.Itoa(limit)
+
+	if value, ok := c.cache().Get(cacheKey); ok {
+		c.cacheHit(ctx, cacheKey)
+		return value.([]*Page), nil
+	}
+
+	c.cacheMiss(ctx, cacheKey)
+
+	// recently modified first
+	cql := "type = page and space = " + quoteCQL(key) + " order by lastmodified desc"
+
+	pages, err := c.getPages(ctx, c.url("content/search"), limit,
+		"cql="+url.QueryEscape(cql),
+		"expand=space,version")
 	if err != nil {
 		return nil, err
 	}
 
-	// create response
-	response := &Response{
-		Status: res.StatusCode,
-		Data:   buf,
-		Header: res.Header,
+	// recent pages change often
+	if c.CacheTTL > 0 {
+		c.cache().Set(cacheKey, pages, c.RecentTTL)
 	}
 
-	// cache it
-	c.responseCache.Set(r.URL.RequestURI(), response, cache.DefaultExpiration)
-
-	return response, nil
+	return pages, nil
 }
 
-func (c *Confluence) Proxy() http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// only proxy get requests
-		if r.Method != "GET" {
-			w.WriteHeader(http.StatusMethodNotAllowed)
-			return
+func (c *Confluence) GetPageByTitle(ctx context.Context, key, title string, expand ...string) (*Page, error) {
+	cacheKey := titleCacheKey(key, title) + expandSuffix(expand)
+
+	if value, ok := c.cachedFresh(cacheKey, func(ctx context.Context) error {
+		_, err := c.fetchPageByTitle(ctx, key, title, expand, cacheKey)
+		return err
+	}); ok {
+		c.cacheHit(ctx, cacheKey)
+		if _, ok := value.(notFoundEntry); ok {
+			return nil, ErrNotFound
+		}
+
+		return value.(*Page), nil
+	}
+
+	c.cacheMiss(ctx, cacheKey)
+
+	// concurrent misses share a single request
+	value, err := c.shared(ctx, cacheKey, func(ctx context.Context) (interface{}, error) {
+		return c.fetchPageByTitle(ctx, key, title, expand, cacheKey)
+	})
+	if err != nil {
+		if value, ok := c.cachedStale(ctx, cacheKey, err); ok {
+			return value.(*Page), nil
+		}
+
+		return nil, err
+	}
+
+	return value.(*Page), nil
+}
+
+func (c *Confluence) fetchPageByTitle(ctx context.Context, key, title string, expand []string, cacheKey string) (*Page, error) {
+	ctx = withSpace(ctx, key)
+
+	var page *Page
+	var err error
+
+	// renaming a page changes its version as well
+	if cached, ok := c.currentPage(ctx, "page", key, cacheKey); ok {
+		copied := *cached
+		page = &copied
+	} else {
+		page, err = c.findPageByTitle(ctx, key, title, expand)
+	}
+	if err != nil {
+		c.cacheNotFound(cacheKey, err)
+		return nil, err
+	}
+
+	page.FetchedAt = time.Now()
+
+	c.cacheFresh(cacheKey, page)
+
+	// allow later lookups by id
+	if page.SpaceKey == "" || page.SpaceKey == key {
+		c.cacheFresh("page-"+key+"-"+page.ID+expandSuffix(expand), page)
+	}
+
+	return page, nil
+}
+
+func (c *Confluence) findPageByTitle(ctx context.Context, key, title string, expand []string) (*Page, error) {
+	_, res, err := c.end(ctx, c.agent().Get(c.url("content")).
+		Set("Accept", "application/json, */*").
+		Query("title="+url.QueryEscape(title)).
+		Query("type=page").
+		Query("spaceKey="+url.QueryEscape(key)).
+		Query("expand="+c.pageExpand(expand)))
+	if err != nil {
+		return nil, err
+	}
+
+	json, err := parseJSON(res)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := json.Path("results").Children()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(results) == 0 {
+		return nil, ErrNotFound
+	}
+
+	return c.parsePage(results[0])
+}
+
+func (c *Confluence) Search(ctx context.Context, cql string, limit int) ([]*Page, error) {
+	pages := make([]*Page, 0)
+
+	// the generic search returns highlighted excerpts
+	err := c.getResults(ctx, c.url("search"), limit, func(obj *gabs.Container) error {
+		page, err := c.parseSearchResult(obj)
+		if err != nil || page == nil {
+			return err
+		}
+
+		pages = append(pages, page)
+
+		return nil
+	}, "cql="+url.QueryEscape(cql), "expand=content.space", "excerpt=highlight")
+	if err != nil {
+		return nil, err
+	}
+
+	return pages, nil
+}
+
+func (c *Confluence) SearchPage(ctx context.Context, cql string, start, limit int) (*PageList, error) {
+	_, res, err := c.end(ctx, c.agent().Get(c.url("search")).
+		Set("Accept", "application/json, */*").
+		Query("cql="+url.QueryEscape(cql)).
+		Query("expand=content.space").
+		Query("excerpt=highlight").
+		Query("start="+strconv.Itoa(start)).
+		Query("limit="+strconv.Itoa(limit)))
+	if err != nil {
+		return nil, err
+	}
+
+	json, err := parseJSON(res)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := json.Path("results").Children()
+	if err != nil {
+		return nil, err
+	}
+
+	list := &PageList{
+		Pages: make([]*Page, 0, len(results)),
+		More:  json.Path("_links.next").Data() != nil,
+	}
+
+	for _, obj := range results {
+		page, err := c.parseSearchResult(obj)
+		if err != nil {
+			return nil, err
+		}
+
+		if page != nil {
+			list.Pages = append(list.Pages, page)
+		}
+	}
+
+	return list, nil
+}
+
+func (c *Confluence) parseSearchResult(obj *gabs.Container) (*Page, error) {
+	// skip results that are not content
+	content := obj.Path("content")
+	if content.Data() == nil {
+		return nil, nil
+	}
+
+	page, err := c.parsePage(content)
+	if err != nil {
+		return nil, err
+	}
+
+	if excerpt, ok := getString(obj, "excerpt"); ok {
+		page.Excerpt = highlightExcerpt(excerpt)
+	}
+
+	return page, nil
+}
+
+func highlightExcerpt(excerpt string) string {
+	// escape the text and mark highlighted terms
+	excerpt = html.EscapeString(html.UnescapeString(excerpt))
+	excerpt = strings.Replace(excerpt, "@@@hl@@@", "<mark>", -1)
+	excerpt = strings.Replace(excerpt, "@@@endhl@@@", "</mark>", -1)
+
+	return excerpt
+}
+
+func (c *Confluence) GetPagesByLabel(ctx context.Context, label, key string, start, limit int) (*PageList, error) {
+	ctx = withSpace(ctx, key)
+
+	cql := "type = page and label = " + quoteCQL(label)
+	if key != "" {
+		cql += " and space = " + quoteCQL(key)
+	}
+
+	return c.SearchPage(ctx, cql, start, limit)
+}
+
+func (c *Confluence) GetSpacePages(ctx context.Context, key, order string, start, limit int) (*PageList, error) {
+	ctx = withSpace(ctx, key)
+
+	cacheKey := "index-" + key + "-" + order + "-" + strconv.Itoa(start) + "-" + strconv.Itoa(limit)
+
+	if value, ok := c.cache().Get(cacheKey); ok {
+		c.cacheHit(ctx, cacheKey)
+		return value.(*PageList), nil
+	}
+
+	c.cacheMiss(ctx, cacheKey)
+
+	cql := "space = " + quoteCQL(key) + " and type = page order by title"
+	if order == "updated" {
+		cql = "space = " + quoteCQL(key) + " and type = page order by lastmodified desc"
+	}
+
+	_, res, err := c.end(ctx, c.agent().Get(c.url("content/search")).
+		Set("Accept", "application/json, */*").
+		Query("cql="+url.QueryEscape(cql)).
+		Query("expand=space,version").
+		Query("start="+strconv.Itoa(start)).
+		Query("limit="+strconv.Itoa(limit)))
+	if err != nil {
+		return nil, err
+	}
+
+	json, err := parseJSON(res)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := json.Path("results").Children()
+	if err != nil {
+		return nil, err
+	}
+
+	list := &PageList{
+		Pages: make([]*Page, 0, len(results)),
+		More:  json.Path("_links.next").Data() != nil,
+	}
+
+	for _, obj := range results {
+		page, err := c.parsePage(obj)
+		if err != nil {
+			return nil, err
+		}
+
+		list.Pages = append(list.Pages, page)
+	}
+
+	c.cacheContent(cacheKey, list)
+
+	return list, nil
+}
+
+func (c *Confluence) GetRootPages(ctx context.Context, key string) ([]*Page, error) {
+	ctx = withSpace(ctx, key)
+
+	cacheKey := "roots-" + key
+
+	if value, ok := c.cache().Get(cacheKey); ok {
+		c.cacheHit(ctx, cacheKey)
+		return value.([]*Page), nil
+	}
+
+	c.cacheMiss(ctx, cacheKey)
+
+	pages, err := c.getPages(ctx, c.url("space/"+url.PathEscape(key)+"/content/page"), 0,
+		"depth=root",
+		"expand=space,version")
+	if err != nil {
+		return nil, err
+	}
+
+	c.cacheContent(cacheKey, pages)
+
+	return pages, nil
+}
+
+func (c *Confluence) GetChildPages(ctx context.Context, id string) ([]*Page, error) {
+	ctx = c.withPageSpace(ctx, id)
+
+	cacheKey := "children-" + id
+
+	if value, ok := c.cache().Get(cacheKey); ok {
+		c.cacheHit(ctx, cacheKey)
+		return value.([]*Page), nil
+	}
+
+	c.cacheMiss(ctx, cacheKey)
+
+	var pages []*Page
+	var err error
+
+	if c.v2() {
+		pages, err = c.getChildPagesV2(ctx, id)
+	} else {
+		pages, err = c.getPages(ctx, c.url("content/"+id+"/child/page"), 0,
+			"expand=space,version")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c.cacheContent(cacheKey, pages)
+
+	return pages, nil
+}
+
+func (c *Confluence) getPages(ctx context.Context, endpoint string, limit int, query ...string) ([]*Page, error) {
+	pages := make([]*Page, 0)
+
+	err := c.getResults(ctx, endpoint, limit, func(obj *gabs.Container) error {
+		page, err := c.parsePage(obj)
+		if err != nil {
+			return err
+		}
+
+		pages = append(pages, page)
+
+		return nil
+	}, query...)
+	if err != nil {
+		return nil, err
+	}
+
+	return pages, nil
+}
+
+func (c *Confluence) getResults(ctx context.Context, endpoint string, limit int, fn func(*gabs.Container) error, query ...string) error {
+	for start, count := 0, 0; limit <= 0 || count < limit; {
+		// get at most the remaining amount
+		size := c.PageSize
+		if limit > 0 && limit-count < size {
+			size = limit - count
+		}
+
+		agent := c.agent().Get(endpoint).
+			Set("Accept", "application/json, */*").
+			Query("start=" + strconv.Itoa(start)).
+			Query("limit=" + strconv.Itoa(size))
+
+		for _, q := range query {
+			agent.Query(q)
+		}
+
+		_, res, err := c.end(ctx, agent)
+		if err != nil {
+			return err
+		}
+
+		json, err := parseJSON(res)
+		if err != nil {
+			return err
+		}
+
+		results, err := json.Path("results").Children()
+		if err != nil {
+			return err
+		}
+
+		for _, obj := range results {
+			if err := fn(obj); err != nil {
+				return err
+			}
+		}
+
+		// stop when there is no next page
+		if len(results) == 0 || json.Path("_links.next").Data() == nil {
+			break
+		}
+
+		start += len(results)
+		count += len(results)
+	}
+
+	return nil
+}
+
+func (c *Confluence) GetUser(ctx context.Context, accountID string) (*User, error) {
+	cacheKey := "user-" + accountID
+
+	if value, ok := c.cache().Get(cacheKey); ok {
+		c.cacheHit(ctx, cacheKey)
+		if _, ok := value.(notFoundEntry); ok {
+			return nil, ErrNotFound
+		}
+
+		return value.(*User), nil
+	}
+
+	c.cacheMiss(ctx, cacheKey)
+
+	_, res, err := c.end(ctx, c.agent().Get(c.url("user")).
+		Set("Accept", "application/json, */*").
+		Query("accountId="+url.QueryEscape(accountID)))
+	if err != nil {
+		c.cacheNotFound(cacheKey, err)
+		return nil, err
+	}
+
+	obj, err := parseJSON(res)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &User{AccountID: accountID}
+
+	// email and picture depend on privacy settings
+	user.DisplayName, _ = getString(obj, "displayName")
+	user.Email, _ = getString(obj, "email")
+	user.ProfilePicture, _ = getString(obj, "profilePicture.path")
+
+	c.cacheContent(cacheKey, user)
+
+	return user, nil
+}
+
+func (c *Confluence) GetPageLabels(ctx context.Context, id string) ([]string, error) {
+	ctx = c.withPageSpace(ctx, id)
+
+	cacheKey := "labels-" + id
+
+	if value, ok := c.cache().Get(cacheKey); ok {
+		c.cacheHit(ctx, cacheKey)
+		return value.([]string), nil
+	}
+
+	c.cacheMiss(ctx, cacheKey)
+
+	var labels []string
+	var err error
+
+	if c.v2() {
+		labels, err = c.getPageLabelsV2(ctx, id)
+	} else {
+		labels, err = c.getPageLabelsV1(ctx, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c.cacheContent(cacheKey, labels)
+
+	return labels, nil
+}
+
+func (c *Confluence) getPageLabelsV1(ctx context.Context, id string) ([]string, error) {
+	labels := make([]string, 0)
+
+	err := c.getResults(ctx, c.url("content/"+id+"/label"), 0, func(obj *gabs.Container) error {
+		if name, ok := getString(obj, "name"); ok {
+			labels = append(labels, name)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return labels, nil
+}
+
+func (c *Confluence) GetComments(ctx context.Context, id string) ([]*Comment, error) {
+	ctx = c.withPageSpace(ctx, id)
+
+	cacheKey := "comments-" + id
+
+	if value, ok := c.cache().Get(cacheKey); ok {
+		c.cacheHit(ctx, cacheKey)
+		return value.([]*Comment), nil
+	}
+
+	c.cacheMiss(ctx, cacheKey)
+
+	comments := make([]*Comment, 0)
+
+	err := c.getResults(ctx, c.url("content/"+id+"/child/comment"), 0, func(obj *gabs.Container) error {
+		comment := &Comment{}
+
+		var ok bool
+		if comment.ID, ok = getString(obj, "id"); !ok {
+			return errors.New("comment without id")
+		}
+
+		// author and date are optional
+		comment.Author, _ = getString(obj, "history.createdBy.displayName")
+
+		if created, ok := getString(obj, "history.createdDate"); ok {
+			comment.CreatedAt, _ = time.Parse(time.RFC3339, created)
+		}
+
+		if body, ok := getString(obj, "body.view.value"); ok {
+			comment.Body = c.processBody(body)
+		}
+
+		comments = append(comments, comment)
+
+		return nil
+	}, "expand=body.view,history")
+	if err != nil {
+		return nil, err
+	}
+
+	c.cacheContent(cacheKey, comments)
+
+	return comments, nil
+}
+
+func (c *Confluence) GetAttachments(ctx context.Context, id string) ([]*AttachmentMeta, error) {
+	ctx = c.withPageSpace(ctx, id)
+
+	cacheKey := "attachments-" + id
+
+	if value, ok := c.cache().Get(cacheKey); ok {
+		c.cacheHit(ctx, cacheKey)
+		return value.([]*AttachmentMeta), nil
+	}
+
+	c.cacheMiss(ctx, cacheKey)
+
+	attachments := make([]*AttachmentMeta, 0)
+
+	err := c.getResults(ctx, c.url("content/"+id+"/child/attachment"), 0, func(obj *gabs.Container) error {
+		attachment := &AttachmentMeta{}
+
+		var ok bool
+		if attachment.ID, ok = getString(obj, "id"); !ok {
+			return errors.New("attachment without id")
+		}
+
+		if attachment.Filename, ok = getString(obj, "title"); !ok {
+			return errors.New("attachment without title: " + attachment.ID)
+		}
+
+		// metadata is optional
+		attachment.MediaType, _ = getString(obj, "extensions.mediaType")
+
+		if size, ok := obj.Path("extensions.fileSize").Data().(float64); ok {
+			attachment.Size = int64(size)
+		}
+
+		if number, ok := obj.Path("version.number").Data().(float64); ok {
+			attachment.Version = int(number)
+		}
+
+		// serve through the download route
+		attachment.Link, _ = getString(obj, "_links.download")
+		if attachment.Link == "" {
+			attachment.Link = "/download/attachments/" + id + "/" + url.PathEscape(attachment.Filename)
+		}
+
+		attachments = append(attachments, attachment)
+
+		return nil
+	}, "expand=version")
+	if err != nil {
+		return nil, err
+	}
+
+	c.cacheContent(cacheKey, attachments)
+
+	return attachments, nil
+}
+
+func (c *Confluence) GetLatestAttachment(ctx context.Context, id, filename string) (*Attachment, error) {
+	ctx = c.withPageSpace(ctx, id)
+
+	link, err := c.latestAttachmentLink(ctx, id, filename)
+	if err != nil {
+		return nil, err
+	}
+
+	// download links carry the current version
+	download, err := url.Parse(link)
+	if err != nil {
+		return nil, err
+	}
+
+	query := download.Query()
+
+	return c.GetAttachment(ctx, id, filename, query.Get("version"), query.Get("modificationDate"), query.Get("api"))
+}
+
+func (c *Confluence) latestAttachmentLink(ctx context.Context, id, filename string) (string, error) {
+	cacheKey := "latest-" + id + "-" + filename
+
+	if value, ok := c.cache().Get(cacheKey); ok {
+		c.cacheHit(ctx, cacheKey)
+		return value.(string), nil
+	}
+
+	c.cacheMiss(ctx, cacheKey)
+
+	_, res, err := c.end(ctx, c.agent().Get(c.url("content/"+id+"/child/attachment")).
+		Set("Accept", "application/json, */*").
+		Query("filename="+url.QueryEscape(filename)).
+		Query("expand=version"))
+	if err != nil {
+		return "", err
+	}
+
+	json, err := parseJSON(res)
+	if err != nil {
+		return "", err
+	}
+
+	results, err := json.Path("results").Children()
+	if err != nil {
+		return "", err
+	}
+
+	// the filter may match loosely
+	for _, obj := range results {
+		if title, _ := getString(obj, "title"); title != filename {
+			continue
+		}
+
+		link, ok := getString(obj, "_links.download")
+		if !ok {
+			return "", errors.New("attachment without download link: " + filename)
+		}
+
+		c.cacheContent(cacheKey, link)
+
+		return link, nil
+	}
+
+	return "", ErrNotFound
+}
+
+func (c *Confluence) Ping(ctx context.Context) error {
+	endpoint := c.url("space")
+	if c.v2() {
+		endpoint = c.urlV2("spaces")
+	}
+
+	_, _, err := c.end(ctx, c.agent().Get(endpoint).
+		Set("Accept", "application/json, */*").
+		Query("limit=1"))
+	return err
+}
+
+func (c *Confluence) GetAttachment(ctx context.Context, id, file, version, date, api string) (*Attachment, error) {
+	cacheKey := "attachment-" + id + "-" + file + "-" + version + "-" + date + "-" + api
+
+	if value, ok := c.cache().Get(cacheKey); ok {
+		c.cacheHit(ctx, cacheKey)
+		c.attachments.touch(cacheKey)
+		return value.(*Attachment), nil
+	}
+
+	c.cacheMiss(ctx, cacheKey)
+	c.attachments.forget(cacheKey)
+
+	// bound the whole download by the timeout
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	res, err := c.openAttachment(ctx, id, file, version, date, api)
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	// refuse announced oversized files early
+	if c.MaxAttachmentSize > 0 && res.ContentLength > c.MaxAttachmentSize {
+		return nil, ErrTooLarge
+	}
+
+	reader := io.Reader(res.Body)
+	if c.MaxAttachmentSize > 0 {
+		reader = io.LimitReader(res.Body, c.MaxAttachmentSize+1)
+	}
+
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.MaxAttachmentSize > 0 && int64(len(data)) > c.MaxAttachmentSize {
+		return nil, ErrTooLarge
+	}
+
+	attachment := &Attachment{
+		ContentType: res.Header.Get("Content-Type"),
+		Data:        data,
+	}
+
+	// guess content type if missing
+	if attachment.ContentType == "" {
+		attachment.ContentType = http.DetectContentType(data)
+	}
+
+	c.cacheAttachment(cacheKey, attachment)
+
+	return attachment, nil
+}
+
+func (c *Confluence) StreamAttachment(ctx context.Context, id, file, version, date, api string) (io.ReadCloser, string, error) {
+	// streams are neither buffered nor cached and only end with the context
+	res, err := c.openAttachment(ctx, id, file, version, date, api)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return res.Body, res.Header.Get("Content-Type"), nil
+}
+
+func (c *Confluence) openAttachment(ctx context.Context, id, file, version, date, api string) (*http.Response, error) {
+	ctx = c.withPageSpace(ctx, id)
+
+	query := url.Values{}
+	query.Set("version", version)
+	query.Set("modificationDate", date)
+	query.Set("api", api)
+
+	req, err := http.NewRequest("GET", c.downloadURL("attachments/"+id+"/"+url.PathEscape(file))+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	auth, err := c.authorization(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", auth)
+
+	if err := c.acquire(ctx); err != nil {
+		return nil, err
+	}
+
+	span := c.startSpan(ctx, req.Method, req.URL.String(), req.Header)
+	start := time.Now()
+
+	res, err := c.httpClient().Do(req.WithContext(ctx))
+	if err != nil {
+		c.observe(span, req.Method, req.URL.String(), start, 0, err)
+		c.release()
+		return nil, err
+	}
+
+	c.observe(span, req.Method, req.URL.String(), start, res.StatusCode, nil)
+
+	res.Body = &releaseBody{ReadCloser: res.Body, release: c.release}
+
+	if err := responseError(res); err != nil {
+		res.Body.Close()
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (c *Confluence) GetResponse(r *http.Request) (*Response, error) {
+	// check cache
+	if value, ok := c.cache().Get(r.URL.RequestURI()); ok {
+		return value.(*Response), nil
+	}
+
+	// make new request
+	// proxied paths always start with the cloud context path
+	r2, err := http.NewRequest("GET", c.endpoint(strings.TrimPrefix(r.URL.RequestURI(), "/wiki")), r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// propagate cancellation and timeout
+	ctx := r.Context()
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	r2 = r2.WithContext(ctx)
+
+	// add authentication
+	auth, err := c.authorization(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	r2.Header.Set("Authorization", auth)
+
+	if err := c.acquire(ctx); err != nil {
+		return nil, err
+	}
+
+	defer c.release()
+
+	// make request
+	span := c.startSpan(ctx, r2.Method, r2.URL.String(), r2.Header)
+	start := time.Now()
+	res, err := c.httpClient().Do(r2)
+	if err != nil {
+		c.observe(span, r2.Method, r2.URL.String(), start, 0, err)
+		return nil, err
+	}
+
+	c.observe(span, r2.Method, r2.URL.String(), start, res.StatusCode, nil)
+
+	defer res.Body.Close()
+
+	// read full body
+	buf, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// create response
+	response := &Response{
+		Status: res.StatusCode,
+		Data:   buf,
+		Header: res.Header,
+	}
+
+	// cache it
+	c.cacheResponse(r.URL.RequestURI(), response)
+
+	return response, nil
+}
+
+func (c *Confluence) Proxy() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// only proxy get requests
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
 		}
 
 		// get response
 		res, err := c.GetResponse(r)
 		if err != nil {
-			println(err.Error())
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+			c.logf("proxy error: %s", err.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		// add headers
+		for key, values := range res.Header {
+			for _, value := range values {
+				w.Header().Add(key, value)
+			}
+		}
+
+		// write head and body
+		w.WriteHeader(res.Status)
+		w.Write(res.Data)
+	})
+}
+
+func (c *Confluence) parseSpace(obj *gabs.Container) (*Space, error) {
+	space := &Space{}
+
+	var ok bool
+	if space.Key, ok = getString(obj, "key"); !ok {
+		return nil, errors.New("space without key")
+	}
+
+	if space.Name, ok = getString(obj, "name"); !ok {
+		return nil, errors.New("space without name: " + space.Key)
+	}
+
+	// description and homepage are optional
+	if description, ok := getString(obj, "description.view.value"); ok {
+		space.Description = c.processBody(description)
+	}
+
+	space.Homepage.ID, _ = getString(obj, "homepage.id")
+	space.Homepage.Title, _ = getString(obj, "homepage.title")
+
+	if body, ok := getString(obj, "homepage.body.view.value"); ok {
+		space.Homepage.Body = c.processBody(body)
+	}
+
+	return space, nil
+}
+
+func (c *Confluence) parsePage(obj *gabs.Container) (*Page, error) {
+	page := &Page{}
+
+	var ok bool
+	if page.ID, ok = getString(obj, "id"); !ok {
+		return nil, ErrNotFound
+	}
+
+	if page.Title, ok = getString(obj, "title"); !ok {
+		return nil, errors.New("page without title: " + page.ID)
+	}
+
+	// type, bodies, space and excerpt are optional
+	if body, ok := getString(obj, "body.view.value"); ok {
+		page.Body, page.TOC = buildTOC(c.processBody(body), c.TOCDepth)
+	}
+
+	page.Type, _ = getString(obj, "type")
+	page.Storage, _ = getString(obj, "body.storage.value")
+	page.SpaceKey, _ = getString(obj, "space.key")
+
+	// remember the space for requests by id
+	if page.SpaceKey != "" && len(c.SpaceCredentials) > 0 {
+		c.pageSpaces.Store(page.ID, page.SpaceKey)
+	}
+	page.Excerpt, _ = getString(obj, "excerpt")
+
+	// labels are only present if expanded
+	if labels, err := obj.Path("metadata.labels.results").Children(); err == nil {
+		page.Labels = make([]string, 0, len(labels))
+		for _, label := range labels {
+			if name, ok := getString(label, "name"); ok {
+				page.Labels = append(page.Labels, name)
+			}
+		}
+	}
+
+	// version is optional and may be anonymous
+	if number, ok := obj.Path("version.number").Data().(float64); ok {
+		page.Version = int(number)
+	}
+
+	page.UpdatedBy, _ = getString(obj, "version.by.displayName")
+	page.UpdatedByID, _ = getString(obj, "version.by.accountId")
+
+	if when, ok := getString(obj, "version.when"); ok {
+		page.UpdatedAt, _ = time.Parse(time.RFC3339, when)
+	}
+
+	// ancestors are ordered from root to parent
+	page.Ancestors = make([]*Page, 0)
+	if ancestors, err := obj.Path("ancestors").Children(); err == nil {
+		for _, ancestor := range ancestors {
+			id, ok1 := getString(ancestor, "id")
+			title, ok2 := getString(ancestor, "title")
+			if ok1 && ok2 {
+				page.Ancestors = append(page.Ancestors, &Page{ID: id, Title: title})
+			}
+		}
+	}
+
+	// link to the web ui, nested results omit the base
+	base, ok := getString(obj, "_links.base")
+	if !ok {
+		base = c.baseURL + c.ContextPath
+	}
+
+	if webui, ok := getString(obj, "_links.webui"); ok {
+		page.Link = base + webui
+	} else {
+		page.Link = base + "/pages/viewpage.action?pageId=" + url.QueryEscape(page.ID)
+	}
+
+	return page, nil
+}
+
+func (c *Confluence) cacheTTL(key string) time.Duration {
+	ttl := c.PagesTTL
+	if kind := cacheType(key); kind == "space" || kind == "spaces" {
+		ttl = c.SpacesTTL
+	}
+
+	// fall back to the global duration
+	if ttl <= 0 {
+		return c.CacheTTL
+	}
+
+	return ttl
+}
+
+func (c *Confluence) cacheContent(key string, value interface{}) {
+	// a zero ttl disables caching
+	if c.CacheTTL > 0 {
+		c.cache().Set(key, value, c.cacheTTL(key))
+	}
+}
+
+func (c *Confluence) cacheFresh(key string, value interface{}) {
+	if c.CacheTTL <= 0 {
+		return
+	}
+
+	// keep entries around for revalidation and upstream failures
+	ttl := c.cacheTTL(key)
+	retain := ttl + c.StaleTTL + c.StaleIfErrorTTL
+
+	// expired pages are kept another period to check their version
+	if page, ok := value.(*Page); ok && page.Version > 0 {
+		retain += ttl
+	}
+
+	if retain == ttl {
+		c.cacheContent(key, value)
+		return
+	}
+
+	c.cache().Set(key, &staleEntry{Value: value, Expires: time.Now().Add(ttl)}, retain)
+}
+
+func (c *Confluence) cachedFresh(key string, refresh func(context.Context) error) (interface{}, bool) {
+	value, ok := c.cache().Get(key)
+	if !ok {
+		return nil, false
+	}
+
+	entry, ok := value.(*staleEntry)
+	if !ok {
+		return value, true
+	}
+
+	// older entries are only kept in case confluence fails
+	if time.Now().After(entry.Expires.Add(c.StaleTTL)) {
+		return nil, false
+	}
+
+	// serve stale entries while refreshing them in the background
+	if refresh != nil && time.Now().After(entry.Expires) {
+		c.revalidate(key, refresh)
+	}
+
+	return entry.Value, true
+}
+
+func (c *Confluence) cachedStale(ctx context.Context, key string, err error) (interface{}, bool) {
+	// missing or forbidden content is not an outage
+	if c.StaleIfErrorTTL <= 0 || errors.Is(err, ErrNotFound) || errors.Is(err, ErrUnauthorized) || errors.Is(err, ErrForbidden) {
+		return nil, false
+	}
+
+	value, ok := c.cache().Get(key)
+	if !ok {
+		return nil, false
+	}
+
+	// entries may also be kept longer to check their version
+	entry, ok := value.(*staleEntry)
+	if !ok || time.Now().After(entry.Expires.Add(c.StaleTTL+c.StaleIfErrorTTL)) {
+		return nil, false
+	}
+
+	c.logf("serving stale: %s (%s)", key, err.Error())
+	traceCache(ctx, "cache stale", key)
+
+	if trace, ok := ctx.Value(cacheTraceKey{}).(*CacheTrace); ok {
+		atomic.AddInt32(&trace.Stale, 1)
+	}
+
+	return entry.Value, true
+}
+
+func (c *Confluence) revalidate(key string, refresh func(context.Context) error) {
+	// only refresh each entry once at a time
+	if _, running := c.refreshing.LoadOrStore(key, true); running {
+		return
+	}
+
+	go func() {
+		defer c.refreshing.Delete(key)
+
+		// failed refreshes keep the stale entry
+		if err := refresh(context.Background()); err != nil {
+			c.logf("refresh error: %s", err.Error())
+		}
+	}()
+}
+
+func (c *Confluence) shared(ctx context.Context, key string, fetch func(context.Context) (interface{}, error)) (interface{}, error) {
+	results := c.group.DoChan(key, func() (interface{}, error) {
+		// callers that go away must not cancel the shared request
+		shared := context.Context(detachedContext{ctx})
+		if c.Timeout > 0 {
+			var cancel context.CancelFunc
+			shared, cancel = context.WithTimeout(shared, c.Timeout)
+			defer cancel()
+		}
+
+		return fetch(shared)
+	})
+
+	// but each caller only waits as long as it is interested
+	select {
+	case result := <-results:
+		return result.Val, result.Err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+func (c *Confluence) cacheNotFound(key string, err error) {
+	// only remember definitive misses
+	if errors.Is(err, ErrNotFound) && c.CacheTTL > 0 && c.NegativeCacheTTL > 0 {
+		c.cache().Set(key, notFoundEntry{}, c.NegativeCacheTTL)
+	}
+}
+
+func (c *Confluence) cacheResponse(key string, value interface{}) {
+	// a zero ttl disables caching
+	if c.CacheTTL > 0 {
+		c.cache().Set(key, value, responseCacheTTL)
+	}
+}
+
+func (c *Confluence) cacheAttachment(key string, attachment *Attachment) {
+	size := int64(len(attachment.Data))
+
+	// files larger than the whole budget are never kept
+	if c.CacheTTL <= 0 || (c.AttachmentCacheSize > 0 && size > c.AttachmentCacheSize) {
+		return
+	}
+
+	// attachments rarely change
+	ttl := c.AttachmentsTTL
+	if ttl <= 0 {
+		ttl = responseCacheTTL
+	}
+
+	// release the budget when the cache drops entries on its own
+	c.evictOnce.Do(func() {
+		if cache, ok := c.cache().(evictingCache); ok {
+			cache.OnEvicted(c.attachments.forget)
+		}
+	})
+
+	c.cache().Set(key, attachment, ttl)
+
+	for _, evicted := range c.attachments.add(key, size, c.AttachmentCacheSize, ttl) {
+		c.cache().Delete(evicted)
+	}
+}
+
+func (c *Confluence) cacheHit(ctx context.Context, key string) {
+	c.logf("cache hit: %s", key)
+	cacheHits.WithLabelValues(cacheType(key)).Inc()
+	traceCache(ctx, "cache hit", key)
+
+	if trace, ok := ctx.Value(cacheTraceKey{}).(*CacheTrace); ok {
+		atomic.AddInt32(&trace.Hits, 1)
+	}
+}
+
+func (c *Confluence) cacheMiss(ctx context.Context, key string) {
+	c.logf("cache miss: %s", key)
+	cacheMisses.WithLabelValues(cacheType(key)).Inc()
+	traceCache(ctx, "cache miss", key)
+
+	if trace, ok := ctx.Value(cacheTraceKey{}).(*CacheTrace); ok {
+		atomic.AddInt32(&trace.Misses, 1)
+	}
+}
+
+func (c *Confluence) logf(format string, v ...interface{}) {
+	// silent without logger
+	if c.Logger != nil {
+		c.Logger.Printf(format, v...)
+	}
+}
+
+func quoteCQL(str string) string {
+	return `"` + strings.Replace(strings.Replace(str, `\`, `\\`, -1), `"`, `\"`, -1) + `"`
+}
+
+func (c *Confluence) pageExpand(extra []string) string {
+	expand := append([]string{}, c.PageExpand...)
+
+	// storage format is only fetched on demand
+	if c.FetchStorage {
+		expand = append(expand, "body.storage")
+	}
+
+	return strings.Join(append(expand, extra...), ",")
+}
+
+func expandSuffix(expand []string) string {
+	// additional expansions are cached separately
+	if len(expand) == 0 {
+		return ""
+	}
+
+	return "?expand=" + strings.Join(expand, ",")
+}
+
+func titleCacheKey(key, title string) string {
+	// separate titles from ids and ignore differences in whitespace
+	return "page-" + key + "-title-" + strings.Join(strings.Fields(title), " ")
+}
+
+func getString(obj *gabs.Container, path string) (string, bool) {
+	str, ok := obj.Path(path).Data().(string)
+	return str, ok
+}
+
+func (c *Confluence) authorization(ctx context.Context) (string, error) {
+	// spaces may use their own account
+	if key, ok := ctx.Value(spaceContextKey{}).(string); ok {
+		if credentials, ok := c.SpaceCredentials[key]; ok {
+			return credentials.authorization(), nil
+		}
+	}
+
+	// prefer oauth client credentials
+	if c.OAuthClientID != "" {
+		token, err := c.oauthToken()
+		if err != nil {
+			return "", err
 		}
 
-		// add headers
-		for key, values := range res.Header {
-			for _, value := range values {
-				w.Header().Add(key, value)
+		return token.Type() + " " + token.AccessToken, nil
+	}
+
+	// then personal access token or basic auth
+	credentials := Credentials{Username: c.username, Password: c.password, Token: c.Token}
+
+	return credentials.authorization(), nil
+}
+
+func (c *Confluence) oauthToken() (*oauth2.Token, error) {
+	c.tokenMutex.Lock()
+
+	// the token source is created once and refreshes expired tokens
+	if c.tokenSource == nil {
+		ctx := context.Background()
+		if c.Transport != nil {
+			ctx = context.WithValue(ctx, oauth2.HTTPClient, &http.Client{Transport: c.Transport})
+		}
+
+		config := &clientcredentials.Config{
+			ClientID:     c.OAuthClientID,
+			ClientSecret: c.OAuthClientSecret,
+			TokenURL:     c.OAuthTokenURL,
+			Scopes:       c.OAuthScopes,
+		}
+
+		c.tokenSource = config.TokenSource(ctx)
+	}
+
+	source := c.tokenSource
+	c.tokenMutex.Unlock()
+
+	return source.Token()
+}
+
+func (c *Confluence) httpClient() *http.Client {
+	// plain client for responses that are not decoded
+	if c.Transport != nil {
+		return &http.Client{Transport: c.Transport}
+	}
+
+	return http.DefaultClient
+}
+
+func (c *Confluence) agent() *gorequest.SuperAgent {
+	// agents keep request state and must not be shared
+	agent := gorequest.New()
+
+	// gorequest swaps the agent transport into its client when sending,
+	// timeouts modify the transport so every agent gets its own copy
+	if c.Transport != nil {
+		agent.Transport = c.Transport.Clone()
+		agent.Transport.DisableKeepAlives = true
+	}
+
+	return agent
+}
+
+func (c *Confluence) end(ctx context.Context, agent *gorequest.SuperAgent) (gorequest.Response, []byte, error) {
+	// add authentication
+	auth, err := c.authorization(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	agent.Set("Authorization", auth)
+
+	for attempt := 0; ; attempt++ {
+		res, body, err := c.attempt(ctx, agent)
+
+		// finish on success, permanent errors or exhausted retries
+		if !retryable(ctx, res, err) || attempt >= c.MaxRetries {
+			if err != nil {
+				return nil, nil, err
+			}
+
+			if err := responseError(res); err != nil {
+				return nil, nil, err
+			}
+
+			if len(body) == 0 {
+				return nil, nil, ErrEmptyResponse
+			}
+
+			if !jsonResponse(res, body) {
+				return nil, nil, UnexpectedResponseError{Status: res.StatusCode, ContentType: res.Header.Get("Content-Type")}
 			}
+
+			return res, body, nil
 		}
 
-		// write head and body
-		w.WriteHeader(res.Status)
-		w.Write(res.Data)
-	})
+		// wait with exponential backoff
+		backoff := c.RetryBackoff << uint(attempt)
+
+		// but at least as long as confluence asks for
+		var limited RateLimitError
+		if res != nil && errors.As(responseError(res), &limited) && limited.RetryAfter > backoff {
+			backoff = limited.RetryAfter
+		}
+		c.logf("retrying in %s", backoff)
+
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+	}
+}
+
+func (c *Confluence) attempt(ctx context.Context, agent *gorequest.SuperAgent) (gorequest.Response, []byte, error) {
+	// clear errors of previous attempts
+	agent.Errors = nil
+
+	// apply timeout, shortened by deadline
+	timeout := c.Timeout
+	if deadline, ok := ctx.Deadline(); ok && (timeout <= 0 || time.Until(deadline) < timeout) {
+		timeout = time.Until(deadline)
+	}
+	if timeout > 0 {
+		agent.Timeout(timeout)
+	} else {
+		agent.Transport.Dial = nil
+	}
+
+	type result struct {
+		res  gorequest.Response
+		body []byte
+		errs []error
+	}
+
+	// bound concurrent upstream requests
+	if err := c.acquire(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	// propagate trace context
+	header := http.Header{}
+	span := c.startSpan(ctx, agent.Method, agent.Url, header)
+	for name := range header {
+		agent.Set(name, header.Get(name))
+	}
+
+	// run request in background
+	done := make(chan result, 1)
+	go func() {
+		defer c.release()
+
+		start := time.Now()
+		res, body, errs := agent.EndBytes()
+
+		// track upstream latency
+		status := 0
+		if res != nil {
+			status = res.StatusCode
+		}
+		var err error
+		if len(errs) > 0 {
+			err = errs[0]
+		}
+		c.observe(span, agent.Method, agent.Url, start, status, err)
+
+		done <- result{res: res, body: body, errs: errs}
+	}()
+
+	// wait for response or cancellation
+	select {
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	case r := <-done:
+		if len(r.errs) > 0 {
+			return nil, nil, RequestError{Err: r.errs[0]}
+		}
+
+		return r.res, r.body, nil
+	}
+}
+
+func (c *Confluence) observe(span trace.Span, method, url string, start time.Time, status int, err error) {
+	duration := time.Since(start)
+	endSpan(span, status, err)
+
+	label := "error"
+	if status > 0 {
+		label = strconv.Itoa(status)
+	}
+	upstreamDuration.WithLabelValues(label).Observe(duration.Seconds())
+
+	// let applications plug in tracing or logging
+	if c.OnRequest != nil {
+		c.OnRequest(method, url, duration, status, err)
+	}
+}
+
+func jsonResponse(res *http.Response, body []byte) bool {
+	// html is never valid json
+	if strings.Contains(res.Header.Get("Content-Type"), "text/html") {
+		return false
+	}
+
+	return !bytes.HasPrefix(bytes.TrimSpace(body), []byte("<"))
+}
+
+func parseJSON(data []byte) (*gabs.Container, error) {
+	obj, err := gabs.ParseJSON(data)
+	if err != nil {
+		return nil, ParseError{Err: err}
+	}
+
+	return obj, nil
+}
+
+func responseError(res *http.Response) error {
+	// throttled requests carry the time to wait
+	if res.StatusCode == http.StatusTooManyRequests {
+		return RateLimitError{RetryAfter: parseRetryAfter(res.Header.Get("Retry-After"))}
+	}
+
+	return statusError(res.StatusCode)
+}
+
+func parseRetryAfter(value string) time.Duration {
+	// the header holds either seconds or a date
+	if seconds, err := strconv.Atoi(strings.TrimSpace(value)); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil && time.Until(date) > 0 {
+		return time.Until(date)
+	}
+
+	return 0
+}
+
+func statusError(code int) error {
+	switch {
+	case code >= 200 && code < 300:
+		return nil
+	case code == http.StatusUnauthorized:
+		return ErrUnauthorized
+	case code == http.StatusForbidden:
+		return ErrForbidden
+	case code == http.StatusNotFound:
+		return ErrNotFound
+	default:
+		return StatusError{Code: code}
+	}
+}
+
+func retryable(ctx context.Context, res gorequest.Response, err error) bool {
+	// never retry cancelled requests
+	if ctx.Err() != nil {
+		return false
+	}
+
+	// retry network errors, throttling and server errors
+	if err != nil {
+		return true
+	}
+
+	return res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500
+}
+
+func (c *Confluence) InvalidatePage(key, id string) {
+	pageKey := "page-" + key + "-" + id
+	prefixes := []string{pageKey}
+
+	// remove title based entry and parent listing
+	if page, ok := c.cachedPage(pageKey); ok {
+		prefixes = append(prefixes, titleCacheKey(key, page.Title))
+
+		if len(page.Ancestors) > 0 {
+			c.cache().Delete("children-" + page.Ancestors[len(page.Ancestors)-1].ID)
+		}
+	}
+
+	// remove entries including those with additional expansions
+	for _, cacheKey := range c.cache().Keys("page-" + key + "-") {
+		// new pages may have been remembered as missing
+		if value, ok := c.cache().Get(cacheKey); ok {
+			if _, ok := value.(notFoundEntry); ok {
+				c.cache().Delete(cacheKey)
+			}
+		}
+
+		for _, prefix := range prefixes {
+			if cacheKey == prefix || strings.HasPrefix(cacheKey, prefix+"?") {
+				c.cache().Delete(cacheKey)
+			}
+		}
+	}
+
+	c.cache().Delete("children-" + id)
+	c.cache().Delete("labels-" + id)
+	c.cache().Delete("comments-" + id)
+	c.cache().Delete("attachments-" + id)
+	c.cache().Delete("ref-" + id)
+
+	// remove resolved attachment versions
+	for _, cacheKey := range c.cache().Keys("latest-" + id + "-") {
+		c.cache().Delete(cacheKey)
+	}
+
+	// remove rendered documents of all versions
+	for _, cacheKey := range c.cache().Keys("pdf-" + id + "-") {
+		c.cache().Delete(cacheKey)
+	}
+}
+
+func (c *Confluence) InvalidateSpace(key string) {
+	c.cache().Delete("spaces-all")
+	c.cache().Delete("space-" + key)
+	c.cache().Delete("roots-" + key)
+
+	// remove all pages of the space
+	for _, prefix := range []string{"page-" + key + "-", "recent-" + key + "-", "index-" + key + "-"} {
+		for _, cacheKey := range c.cache().Keys(prefix) {
+			c.cache().Delete(cacheKey)
+		}
+	}
 }
 
 func (c *Confluence) Reset() {
-	c.contentCache = cache.New(30*time.Minute, time.Minute)
-	c.responseCache = cache.New(24*time.Hour, time.Hour)
+	c.cache().Flush()
+	c.attachments.reset()
+}
+
+func (c *Confluence) cache() Cache {
+	// the default cache is built once the cleanup interval is configured
+	c.cacheOnce.Do(func() {
+		if c.Cache == nil {
+			c.Cache = NewMemoryCache(c.CacheCleanup)
+		}
+	})
+
+	return c.Cache
+}
+
+func (c *Confluence) cachedPage(key string) (*Page, bool) {
+	value, ok := c.cachedFresh(key, nil)
+	if !ok {
+		return nil, false
+	}
+
+	page, ok := value.(*Page)
+	return page, ok
 }
 
 func (c *Confluence) processBody(body string) string {
-	body = c.sanitizer.Sanitize(body)
-	return strings.Replace(body, c.baseURL+"/", "/", -1)
+	// trusted deployments may disable sanitization
+	if c.Sanitizer != nil {
+		body = c.Sanitizer.Sanitize(body)
+	}
+
+	// server bodies link relative to the context path
+	if c.ContextPath != "/wiki" {
+		body = relativeLinkPattern.ReplaceAllStringFunc(body, func(attr string) string {
+			i := strings.Index(attr, `"`) + 1
+			if path := attr[i:]; strings.HasPrefix(path, c.ContextPath+"/") && !strings.HasPrefix(path, "//") {
+				return attr[:i] + "/wiki/" + path[len(c.ContextPath)+1:]
+			}
+
+			return attr
+		})
+	}
+
+	// use cloud style paths so links are rewritten the same way for all flavors
+	return strings.Replace(body, c.baseURL+c.ContextPath+"/", "/wiki/", -1)
 }
+
+var relativeLinkPattern = regexp.MustCompile(`\s(?:href|src)="/[^"]*`)
@@ -0,0 +1,675 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestURLs(t *testing.T) {
+	tests := []struct {
+		baseURL  string
+		path     string
+		url      string
+		download string
+	}{
+		{"https://example.com", "space", "https://example.com/wiki/rest/api/space", "https://example.com/wiki/download/space"},
+		{"https://example.com/", "/space", "https://example.com/wiki/rest/api/space", "https://example.com/wiki/download/space"},
+		{" https://example.com// ", "content/1", "https://example.com/wiki/rest/api/content/1", "https://example.com/wiki/download/content/1"},
+		{"https://example.com/base/", "attachments/1/a.png", "https://example.com/base/wiki/rest/api/attachments/1/a.png", "https://example.com/base/wiki/download/attachments/1/a.png"},
+	}
+
+	for _, test := range tests {
+		c := NewConfluence(test.baseURL, "user", "secret")
+
+		if got := c.url(test.path); got != test.url {
+			t.Errorf("url(%q) with base %q = %q; want %q", test.path, test.baseURL, got, test.url)
+		}
+		if got := c.downloadURL(test.path); got != test.download {
+			t.Errorf("downloadURL(%q) with base %q = %q; want %q", test.path, test.baseURL, got, test.download)
+		}
+	}
+}
+
+func TestContextPath(t *testing.T) {
+	tests := []struct {
+		flavor      string
+		contextPath string
+		want        string
+		endpoint    string
+	}{
+		{"", "", "/wiki", "https://example.com/wiki/rest/api/space"},
+		{"cloud", "", "/wiki", "https://example.com/wiki/rest/api/space"},
+		{"server", "", "", "https://example.com/rest/api/space"},
+		{"server", "confluence/", "/confluence", "https://example.com/confluence/rest/api/space"},
+		{"server", "/confluence", "/confluence", "https://example.com/confluence/rest/api/space"},
+	}
+
+	for _, test := range tests {
+		c, err := NewConfluenceFromConfig(ConfluenceConfig{
+			BaseURL:     "https://example.com/",
+			Flavor:      test.flavor,
+			ContextPath: test.contextPath,
+			Token:       "secret",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if c.ContextPath != test.want || c.url("space") != test.endpoint {
+			t.Errorf("flavor %q with context path %q = %q, %q; want %q, %q", test.flavor, test.contextPath, c.ContextPath, c.url("space"), test.want, test.endpoint)
+		}
+	}
+}
+
+func TestProcessBody(t *testing.T) {
+	tests := []struct {
+		contextPath string
+		body        string
+		want        string
+	}{
+		{
+			"/wiki",
+			`<a href="https://example.com/wiki/spaces/ENG/pages/1/Home">home</a>`,
+			`<a href="/wiki/spaces/ENG/pages/1/Home">home</a>`,
+		},
+		{
+			"",
+			`<a href="https://example.com/display/ENG/Home">home</a>`,
+			`<a href="/wiki/display/ENG/Home">home</a>`,
+		},
+		{
+			"",
+			`<a href="/display/ENG/Home">home</a> <img src="/download/attachments/1/a.png">`,
+			`<a href="/wiki/display/ENG/Home">home</a> <img src="/wiki/download/attachments/1/a.png">`,
+		},
+		{
+			"/confluence",
+			`<a href="/confluence/pages/viewpage.action?pageId=1">home</a>`,
+			`<a href="/wiki/pages/viewpage.action?pageId=1">home</a>`,
+		},
+		{
+			"/confluence",
+			`<a href="/other/page">other</a> <a href="//cdn.example.com/confluence/a">cdn</a>`,
+			`<a href="/other/page">other</a> <a href="//cdn.example.com/confluence/a">cdn</a>`,
+		},
+		{
+			"",
+			`<a href="//cdn.example.com/a">cdn</a> <p>/display/ENG</p>`,
+			`<a href="//cdn.example.com/a">cdn</a> <p>/display/ENG</p>`,
+		},
+	}
+
+	for _, test := range tests {
+		c := NewConfluence("https://example.com", "user", "secret")
+		c.ContextPath = test.contextPath
+		c.Sanitizer = nil
+
+		if got := c.processBody(test.body); got != test.want {
+			t.Errorf("processBody(%q) with context path %q = %q; want %q", test.body, test.contextPath, got, test.want)
+		}
+	}
+}
+
+func TestSharedFetch(t *testing.T) {
+	var requests int32
+	release := make(chan struct{})
+
+	confluence := newTestConfluence(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		<-release
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"1","type":"page","title":"Home","space":{"key":"ENG"},"version":{"number":1}}`))
+	})
+
+	tests := []struct {
+		name   string
+		cancel bool
+	}{
+		{"all callers waiting", false},
+		{"first caller gone", true},
+	}
+
+	for _, test := range tests {
+		confluence.Reset()
+		atomic.StoreInt32(&requests, 0)
+		release = make(chan struct{})
+
+		// the first caller starts the shared request
+		first, cancel := context.WithCancel(context.Background())
+		firstErr := make(chan error, 1)
+		go func() {
+			_, err := confluence.GetPageByID(first, "ENG", "1")
+			firstErr <- err
+		}()
+
+		for atomic.LoadInt32(&requests) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 5)
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := confluence.GetPageByID(context.Background(), "ENG", "1")
+				errs <- err
+			}()
+		}
+
+		// give the other callers time to join
+		time.Sleep(20 * time.Millisecond)
+
+		if test.cancel {
+			cancel()
+			if err := <-firstErr; !errors.Is(err, context.Canceled) {
+				t.Errorf("%s: first caller got %v; want %v", test.name, err, context.Canceled)
+			}
+		}
+
+		close(release)
+		wg.Wait()
+		close(errs)
+		cancel()
+
+		for err := range errs {
+			if err != nil {
+				t.Errorf("%s: waiting caller got %v", test.name, err)
+			}
+		}
+
+		if requests := atomic.LoadInt32(&requests); requests != 1 {
+			t.Errorf("%s: sent %d requests; want 1", test.name, requests)
+		}
+	}
+}
+
+func TestRetries(t *testing.T) {
+	tests := []struct {
+		statuses   []int
+		maxRetries int
+		attempts   int
+		err        error
+	}{
+		{[]int{200}, 2, 1, nil},
+		{[]int{503, 200}, 2, 2, nil},
+		{[]int{502, 500, 200}, 2, 3, nil},
+		{[]int{503, 503, 503, 200}, 2, 3, StatusError{Code: 503}},
+		{[]int{503, 200}, 0, 1, StatusError{Code: 503}},
+		{[]int{404, 200}, 2, 1, ErrNotFound},
+		{[]int{401, 200}, 2, 1, ErrUnauthorized},
+		{[]int{400, 200}, 2, 1, StatusError{Code: 400}},
+	}
+
+	for _, test := range tests {
+		var attempts int32
+
+		confluence := newTestConfluence(t, func(w http.ResponseWriter, r *http.Request) {
+			status := test.statuses[atomic.AddInt32(&attempts, 1)-1]
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			w.Write([]byte(`{"results":[]}`))
+		})
+
+		confluence.MaxRetries = test.maxRetries
+
+		_, _, err := confluence.end(context.Background(), confluence.agent().Get(confluence.url("space")))
+		if !errors.Is(err, test.err) && err != test.err {
+			t.Errorf("statuses %v with %d retries: got error %v; want %v", test.statuses, test.maxRetries, err, test.err)
+		}
+		if int(attempts) != test.attempts {
+			t.Errorf("statuses %v with %d retries: sent %d requests; want %d", test.statuses, test.maxRetries, attempts, test.attempts)
+		}
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	var times []time.Time
+
+	confluence := newTestConfluence(t, func(w http.ResponseWriter, r *http.Request) {
+		times = append(times, time.Now())
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	confluence.MaxRetries = 3
+	confluence.RetryBackoff = 10 * time.Millisecond
+
+	confluence.end(context.Background(), confluence.agent().Get(confluence.url("space")))
+
+	if len(times) != 4 {
+		t.Fatalf("sent %d requests; want 4", len(times))
+	}
+
+	// every wait doubles the previous one
+	for i := 1; i < len(times); i++ {
+		if wait, min := times[i].Sub(times[i-1]), confluence.RetryBackoff<<uint(i-1); wait < min {
+			t.Errorf("attempt %d waited %s; want at least %s", i+1, wait, min)
+		}
+	}
+}
+
+func TestRetryCancelled(t *testing.T) {
+	var attempts int32
+
+	confluence := newTestConfluence(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	confluence.MaxRetries = 5
+	confluence.RetryBackoff = time.Hour
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, _, err := confluence.end(ctx, confluence.agent().Get(confluence.url("space")))
+	if !errors.Is(err, context.DeadlineExceeded) || attempts != 1 {
+		t.Errorf("got %v after %d requests; want %v after 1", err, attempts, context.DeadlineExceeded)
+	}
+}
+
+func TestPageTitleQuery(t *testing.T) {
+	tests := []struct {
+		key   string
+		title string
+	}{
+		{"ENG", "Home"},
+		{"ENG", "Release 1.0 & Beyond"},
+		{"ENG", "What? 100% #1 + more"},
+		{"~user", "a=b;c"},
+	}
+
+	for _, test := range tests {
+		var key, title string
+
+		confluence := newTestConfluence(t, func(w http.ResponseWriter, r *http.Request) {
+			key = r.URL.Query().Get("spaceKey")
+			title = r.URL.Query().Get("title")
+
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"results":[{"id":"1","type":"page","title":"x","space":{"key":"ENG"}}]}`))
+		})
+
+		if _, err := confluence.GetPageByTitle(context.Background(), test.key, test.title); err != nil {
+			t.Fatal(err)
+		}
+
+		if key != test.key || title != test.title {
+			t.Errorf("GetPageByTitle(%q, %q) sent %q, %q", test.key, test.title, key, title)
+		}
+	}
+}
+
+func TestConcurrentRequests(t *testing.T) {
+	confluence := newTestConfluence(t, func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/wiki/rest/api/content/")
+
+		// shared agents would leak query parameters between requests
+		if len(r.URL.Query()["expand"]) != 1 || len(r.URL.Query()["type"]) != 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"` + id + `","type":"page","title":"Page ` + id + `","space":{"key":"ENG"}}`))
+	})
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 20; i++ {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+
+			page, err := confluence.GetPageByID(context.Background(), "ENG", id)
+			if err != nil {
+				t.Errorf("GetPageByID(%s): %v", id, err)
+				return
+			}
+			if page.ID != id || page.Title != "Page "+id {
+				t.Errorf("GetPageByID(%s) = %s %q", id, page.ID, page.Title)
+			}
+		}(strconv.Itoa(i))
+	}
+
+	wg.Wait()
+}
+
+func TestPageLink(t *testing.T) {
+	tests := []struct {
+		contextPath string
+		json        string
+		link        string
+	}{
+		{
+			"/wiki",
+			`{"id":"1","title":"Home","_links":{"base":"https://cloud.example.com/wiki","webui":"/spaces/ENG/pages/1/Home"}}`,
+			"https://cloud.example.com/wiki/spaces/ENG/pages/1/Home",
+		},
+		{
+			"/wiki",
+			`{"id":"1","title":"Home","_links":{"webui":"/spaces/ENG/pages/1/Home"}}`,
+			"https://example.com/wiki/spaces/ENG/pages/1/Home",
+		},
+		{
+			"/wiki",
+			`{"id":"1","title":"Home"}`,
+			"https://example.com/wiki/pages/viewpage.action?pageId=1",
+		},
+		{
+			"",
+			`{"id":"1","title":"Home","_links":{"webui":"/display/ENG/Home"}}`,
+			"https://example.com/display/ENG/Home",
+		},
+	}
+
+	for _, test := range tests {
+		c := NewConfluence("https://example.com", "user", "secret")
+		c.ContextPath = test.contextPath
+
+		obj, err := parseJSON([]byte(test.json))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		page, err := c.parsePage(obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if page.Link != test.link {
+			t.Errorf("link of %s = %q; want %q", test.json, page.Link, test.link)
+		}
+	}
+}
+
+func TestNegativeCache(t *testing.T) {
+	tests := []struct {
+		status   int
+		ttl      time.Duration
+		requests int32
+	}{
+		{http.StatusNotFound, time.Minute, 1},
+		{http.StatusNotFound, 0, 2},
+		{http.StatusForbidden, time.Minute, 2},
+		{http.StatusInternalServerError, time.Minute, 2},
+	}
+
+	for _, test := range tests {
+		var requests int32
+
+		confluence := newTestConfluence(t, func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			w.WriteHeader(test.status)
+		})
+
+		confluence.NegativeCacheTTL = test.ttl
+
+		for i := 0; i < 2; i++ {
+			if _, err := confluence.GetPageByID(context.Background(), "ENG", "1"); err == nil {
+				t.Errorf("status %d: got no error", test.status)
+			}
+		}
+
+		if requests != test.requests {
+			t.Errorf("status %d with ttl %s: sent %d requests; want %d", test.status, test.ttl, requests, test.requests)
+		}
+	}
+}
+
+func TestStaleCache(t *testing.T) {
+	var requests int32
+	refreshed := make(chan struct{}, 1)
+
+	confluence := newTestConfluence(t, func(w http.ResponseWriter, r *http.Request) {
+		name := "First"
+		if atomic.AddInt32(&requests, 1) > 1 {
+			name = "Second"
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"key":"ENG","name":"` + name + `"}`))
+
+		if name == "Second" {
+			select {
+			case refreshed <- struct{}{}:
+			default:
+			}
+		}
+	})
+
+	confluence.CacheTTL = 20 * time.Millisecond
+	confluence.StaleTTL = time.Minute
+
+	fetch := func() string {
+		space, err := confluence.GetSpace(context.Background(), "ENG")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return space.Name
+	}
+
+	// fetched and cached
+	if name := fetch(); name != "First" {
+		t.Fatalf("first fetch = %q; want %q", name, "First")
+	}
+
+	// expired but served while refreshing
+	time.Sleep(30 * time.Millisecond)
+
+	if name := fetch(); name != "First" {
+		t.Errorf("expired fetch = %q; want %q", name, "First")
+	}
+
+	select {
+	case <-refreshed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("entry was not refreshed in the background")
+	}
+
+	// the refreshed entry replaces the stale one shortly after
+	deadline := time.Now().Add(5 * time.Second)
+	for fetch() != "Second" {
+		if time.Now().After(deadline) {
+			t.Fatal("refreshed entry was never served")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestStaleIfError(t *testing.T) {
+	var status int32 = http.StatusOK
+
+	confluence := newTestConfluence(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+		w.Write([]byte(`{"key":"ENG","name":"Engineering"}`))
+	})
+
+	confluence.CacheTTL = 20 * time.Millisecond
+	confluence.StaleIfErrorTTL = 500 * time.Millisecond
+
+	if _, err := confluence.GetSpace(context.Background(), "ENG"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		wait   time.Duration
+		status int32
+		err    error
+	}{
+		// expired entries are served during outages
+		{30 * time.Millisecond, http.StatusServiceUnavailable, nil},
+		// but not for missing content
+		{0, http.StatusNotFound, ErrNotFound},
+		// and not beyond their lifetime
+		{500 * time.Millisecond, http.StatusServiceUnavailable, StatusError{Code: http.StatusServiceUnavailable}},
+	}
+
+	for i, test := range tests {
+		time.Sleep(test.wait)
+		atomic.StoreInt32(&status, test.status)
+
+		confluence.NegativeCacheTTL = 0
+
+		space, err := confluence.GetSpace(context.Background(), "ENG")
+		if err != test.err {
+			t.Errorf("fetch %d: got error %v; want %v", i+1, err, test.err)
+		}
+		if err == nil && space.Name != "Engineering" {
+			t.Errorf("fetch %d = %q; want stale space", i+1, space.Name)
+		}
+	}
+}
+
+func TestPageTitleCache(t *testing.T) {
+	tests := []struct {
+		name  string
+		first func(c *Confluence) (*Page, error)
+		title string
+	}{
+		{"title", func(c *Confluence) (*Page, error) {
+			return c.GetPageByTitle(context.Background(), "ENG", "Getting Started")
+		}, "Getting Started"},
+		{"whitespace", func(c *Confluence) (*Page, error) {
+			return c.GetPageByTitle(context.Background(), "ENG", "Getting Started")
+		}, " Getting  Started "},
+		{"id", func(c *Confluence) (*Page, error) {
+			return c.GetPageByID(context.Background(), "ENG", "1")
+		}, "Getting Started"},
+	}
+
+	for _, test := range tests {
+		var requests int32
+
+		confluence := newTestConfluence(t, func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+
+			page := `{"id":"1","type":"page","title":"Getting Started","space":{"key":"ENG"},"body":{"view":{"value":"<p>start</p>"}}}`
+			if strings.HasSuffix(r.URL.Path, "/content") {
+				page = `{"results":[` + page + `]}`
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(page))
+		})
+
+		if _, err := test.first(confluence); err != nil {
+			t.Fatal(err)
+		}
+
+		page, err := confluence.GetPageByTitle(context.Background(), "ENG", test.title)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if page.ID != "1" {
+			t.Errorf("%s: GetPageByTitle(%q) = page %q; want page 1", test.name, test.title, page.ID)
+		}
+		if requests != 1 {
+			t.Errorf("%s: sent %d requests; want 1", test.name, requests)
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	tests := []struct {
+		retryAfter string
+		maxRetries int
+		attempts   int32
+		err        error
+	}{
+		{"2", 0, 1, RateLimitError{RetryAfter: 2 * time.Second}},
+		{"", 0, 1, RateLimitError{}},
+		{"", 1, 2, nil},
+	}
+
+	for _, test := range tests {
+		var attempts int32
+
+		confluence := newTestConfluence(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+
+			if atomic.AddInt32(&attempts, 1) == 1 {
+				if test.retryAfter != "" {
+					w.Header().Set("Retry-After", test.retryAfter)
+				}
+				w.WriteHeader(http.StatusTooManyRequests)
+				w.Write([]byte(`{"message":"rate limited"}`))
+				return
+			}
+
+			w.Write([]byte(`{"results":[]}`))
+		})
+
+		confluence.MaxRetries = test.maxRetries
+
+		_, _, err := confluence.end(context.Background(), confluence.agent().Get(confluence.url("space")))
+		if err != test.err {
+			t.Errorf("Retry-After %q with %d retries: got error %v; want %v", test.retryAfter, test.maxRetries, err, test.err)
+		}
+		if attempts != test.attempts {
+			t.Errorf("Retry-After %q with %d retries: sent %d requests; want %d", test.retryAfter, test.maxRetries, attempts, test.attempts)
+		}
+	}
+}
+
+func TestRateLimitWait(t *testing.T) {
+	var attempts int32
+
+	confluence := newTestConfluence(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		w.Write([]byte(`{"results":[]}`))
+	})
+
+	confluence.MaxRetries = 1
+
+	start := time.Now()
+
+	if _, _, err := confluence.end(context.Background(), confluence.agent().Get(confluence.url("space"))); err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %s; want at least 1s", elapsed)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		value string
+		min   time.Duration
+		max   time.Duration
+	}{
+		{"", 0, 0},
+		{"0", 0, 0},
+		{"-5", 0, 0},
+		{"soon", 0, 0},
+		{"30", 30 * time.Second, 30 * time.Second},
+		{" 7 ", 7 * time.Second, 7 * time.Second},
+		{time.Now().Add(time.Minute).UTC().Format(http.TimeFormat), 58 * time.Second, time.Minute},
+		{time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat), 0, 0},
+	}
+
+	for _, test := range tests {
+		if got := parseRetryAfter(test.value); got < test.min || got > test.max {
+			t.Errorf("parseRetryAfter(%q) = %s; want between %s and %s", test.value, got, test.min, test.max)
+		}
+	}
+}
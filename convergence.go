@@ -1,64 +1,338 @@
 package main
 
 import (
+	"compress/gzip"
+	"context"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"html/template"
+	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/Jeffail/gabs"
+	"github.com/microcosm-cc/bluemonday"
 	"github.com/pressly/chi"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/unrolled/render"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Convergence struct {
-	HomeSpaceKey  string
-	HomePageTitle string
+	Addr             string
+	PublicURL        string
+	HomeSpaceKey     string
+	HomePageTitle    string
+	HomeFile         string
+	ShutdownTimeout  time.Duration
+	GzipLevel        int
+	Minify           bool
+	AdminToken       string
+	WebhookSecret    string
+	FaviconFile      string
+	Robots           string
+	ReadyTimeout     time.Duration
+	ReadyInterval    time.Duration
+	LinkRules        []LinkRule
+	AllowedSpaces    []string
+	DeniedSpaces     []string
+	AccessLog        Logger
+	AccessLogJSON    bool
+	SitemapTTL       time.Duration
+	FeedSize         int
+	TLSAddr          string
+	TLSCertFile      string
+	TLSKeyFile       string
+	CORSOrigins      []string
+	CORSMethods      []string
+	CORSHeaders      []string
+	APIExpand        []string
+	RateLimit        float64
+	RateBurst        int
+	RateLimitBySpace bool
+	TrustProxy       bool
+	TemplatesDir     string
+	AssetsDir        string
+	PDFRenderer      PDFRenderer
+	NotFoundTemplate string
+	AuthTemplate     string
+	UpstreamTemplate string
+	ErrorTemplate    string
+	AssetMaxAge      time.Duration
+	PageMaxAge       time.Duration
+	TopPagesSize     int
+	TopPagesWindow   time.Duration
+	UppercaseKeys    bool
+	DefaultSpace     string
+	ListSpaces       bool
+	TracerProvider   trace.TracerProvider
 
 	confluence *Confluence
 	proxy      http.Handler
 	router     *chi.Mux
 	render     *render.Render
+	assets     http.FileSystem
+
+	readyMutex sync.Mutex
+	readyTime  time.Time
+	readyError error
+
+	limiter rateLimiter
+	views   pageViews
+
+	assetTags sync.Map
+
+	sitemapMutex sync.Mutex
+	sitemapTime  time.Time
+	sitemap      []sitemapEntry
 }
 
 func NewConvergence(confluence *Confluence, homeSpaceKey, homePageTitle string) *Convergence {
-	return &Convergence{
-		HomeSpaceKey:  homeSpaceKey,
-		HomePageTitle: homePageTitle,
+	c := &Convergence{
+		HomeSpaceKey:     homeSpaceKey,
+		HomePageTitle:    homePageTitle,
+		ShutdownTimeout:  10 * time.Second,
+		GzipLevel:        gzip.DefaultCompression,
+		ReadyTimeout:     5 * time.Second,
+		ReadyInterval:    10 * time.Second,
+		LinkRules:        DefaultLinkRules,
+		SitemapTTL:       time.Hour,
+		FeedSize:         20,
+		TLSAddr:          ":8443",
+		CORSMethods:      []string{"GET", "OPTIONS"},
+		CORSHeaders:      []string{"Accept", "Content-Type"},
+		APIExpand:        DefaultAPIExpand,
+		RateBurst:        20,
+		NotFoundTemplate: "404",
+		AuthTemplate:     "401",
+		UpstreamTemplate: "502",
+		ErrorTemplate:    "503",
+		AssetMaxAge:      24 * time.Hour,
+		TopPagesSize:     10,
+		TopPagesWindow:   24 * time.Hour,
+		Robots:           DefaultRobots,
 
 		confluence: confluence,
 		proxy:      confluence.Proxy(),
 		router:     chi.NewRouter(),
-		render: render.New(render.Options{
-			Extensions: []string{".html"},
-			Layout:     "layout",
-		}),
 	}
+
+	c.render = c.newRender()
+
+	return c
+}
+
+func (c *Convergence) newRender() *render.Render {
+	// overrides replace compiled in templates one by one
+	source := templateSource{dir: c.TemplatesDir}
+
+	return render.New(render.Options{
+		Directory:  "templates",
+		Asset:      source.Asset,
+		AssetNames: source.AssetNames,
+		Extensions: []string{".html"},
+		Layout:     "layout",
+		Funcs: []template.FuncMap{{
+			"body":     c.processBody,
+			"excerpt":  c.processExcerpt,
+			"filesize": formatSize,
+			"ago":      formatAge,
+			"duration": formatDuration,
+		}},
+	})
 }
 
-func (c *Convergence) Run() {
+func (c *Convergence) Run() error {
+	// pick up configured templates
+	if c.TemplatesDir != "" {
+		c.render = c.newRender()
+	}
+
+	c.assets = assetFileSystem(c.AssetsDir)
+
+	c.router.Use(c.accessLogMiddleware)
+	c.router.Use(c.traceMiddleware)
+	c.router.Use(c.slashMiddleware)
+	c.router.Use(c.gzipMiddleware)
+	c.router.Use(c.minifyMiddleware)
+	c.router.Use(c.staleMiddleware)
+	c.router.Use(c.assetMiddleware)
+	c.router.Use(c.corsMiddleware)
 	c.router.Use(c.proxyMiddleware)
 
-	c.router.Get("/", c.viewRoot)
-	c.router.Get("/:key", c.viewSpace)
-	c.router.Get("/:key/:id/:title", c.viewPage)
-	c.router.Get("/reset", c.handleReset)
-	c.router.FileServer("/assets", http.Dir("./assets"))
+	c.router.Get("/favicon.ico", c.viewFavicon)
+	c.router.Get("/robots.txt", c.viewRobots)
+	c.router.Get("/", instrument("root", c.limit(c.viewRoot)))
+	c.router.Get("/:key", instrument("space", c.limit(c.viewSpace)))
+	c.router.Get("/:key/:id/:title", instrument("page", c.limit(c.viewPage)))
+	c.router.Get("/:key/:id/:title/print", instrument("print", c.limit(c.viewPrint)))
+	c.router.Get("/x/:tiny", instrument("tiny", c.limit(c.viewTinyLink)))
+	c.router.Get("/display/:key/:title", instrument("display", c.limit(c.viewDisplay)))
+	c.router.Get("/blog/:key", instrument("blog", c.limit(c.viewBlog)))
+	c.router.Get("/blog/:key/:id/:title", instrument("blogpost", c.limit(c.viewBlogPost)))
+	c.router.Get("/pages/:key", instrument("pages", c.limit(c.viewPages)))
+	c.router.Get("/feed/:key", instrument("feed", c.limit(c.viewFeed)))
+	c.router.Get("/sitemap.xml", instrument("sitemap", c.limit(c.viewSitemap)))
+	c.router.Get("/search", instrument("search", c.limit(c.viewSearch)))
+	c.router.Get("/label/:name", instrument("label", c.limit(c.viewLabel)))
+	c.router.Get("/download/attachments/:id/:file", instrument("attachment", c.limit(c.viewAttachment)))
+	c.router.Get("/metrics", promhttp.Handler().ServeHTTP)
+	c.router.Get("/healthz", c.handleHealth)
+	c.router.Get("/readyz", c.handleReady)
+	c.router.Post("/cache/invalidate", instrument("invalidate", c.webhook(c.handleInvalidate)))
+	c.router.Post("/cache/warm", instrument("warm", c.admin(c.handleWarm)))
+	c.router.Get("/admin/cache", instrument("admin-cache", c.admin(c.handleCacheStats)))
+	c.router.Delete("/admin/cache", instrument("admin-flush", c.admin(c.handleCacheFlush)))
+	c.router.Route("/api", func(r chi.Router) {
+		r.Get("/spaces", instrument("api-spaces", c.limit(c.apiSpaces)))
+		r.Get("/spaces/:key", instrument("api-space", c.limit(c.apiSpace)))
+		r.Get("/page/:key/:id", instrument("api-page", c.limit(c.apiPage)))
+	})
+	c.router.FileServer("/assets", c.assets)
 
 	c.router.NotFound(c.handleNotFound)
 
-	fmt.Printf("Running on 0.0.0.0:%s...\n", os.Getenv("PORT"))
-	http.ListenAndServe(":"+os.Getenv("PORT"), c.router)
+	server := &http.Server{
+		Addr:    c.address(),
+		Handler: c.router,
+	}
+
+	servers := []*http.Server{server}
+	errs := make(chan error, 2)
+
+	// serve https and redirect plain requests
+	if c.TLSCertFile != "" && c.TLSKeyFile != "" {
+		server.Handler = http.HandlerFunc(c.redirectTLS)
+
+		secure := &http.Server{
+			Addr:    c.TLSAddr,
+			Handler: c.router,
+		}
+
+		servers = append(servers, secure)
+
+		go func() {
+			fmt.Printf("Running on %s (TLS)...\n", secure.Addr)
+			errs <- secure.ListenAndServeTLS(c.TLSCertFile, c.TLSKeyFile)
+		}()
+	}
+
+	// serve in background
+	go func() {
+		fmt.Printf("Running on %s...\n", server.Addr)
+		errs <- server.ListenAndServe()
+	}()
+
+	// wait for failure or signal
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	var err error
+	select {
+	case err = <-errs:
+	case <-signals:
+		fmt.Println("Shutting down...")
+	}
+
+	// let in-flight requests finish
+	ctx, cancel := context.WithTimeout(context.Background(), c.ShutdownTimeout)
+	defer cancel()
+
+	// stop all listeners together
+	for _, server := range servers {
+		if shutdownErr := server.Shutdown(ctx); err == nil {
+			err = shutdownErr
+		}
+	}
+
+	return err
+}
+
+func (c *Convergence) redirectTLS(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	// keep non standard ports
+	if _, port, err := net.SplitHostPort(c.TLSAddr); err == nil && port != "" && port != "443" {
+		host = net.JoinHostPort(host, port)
+	}
+
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+}
+
+func (c *Convergence) address() string {
+	// use explicit address
+	if c.Addr != "" {
+		return c.Addr
+	}
+
+	// fallback to port
+	if port := os.Getenv("PORT"); port != "" {
+		return ":" + port
+	}
+
+	return ":8080"
+}
+
+func (c *Convergence) localBase() string {
+	// use the configured public address
+	if c.PublicURL != "" {
+		return strings.TrimSuffix(c.PublicURL, "/")
+	}
+
+	// otherwise reach this server through its own listener
+	host, port, err := net.SplitHostPort(c.address())
+	if err != nil {
+		return "http://localhost"
+	}
+
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		host = "localhost"
+	}
+
+	return "http://" + net.JoinHostPort(host, port)
 }
 
 func (c *Convergence) viewRoot(w http.ResponseWriter, r *http.Request) {
+	// send single space deployments straight to their space
+	if c.DefaultSpace != "" {
+		http.Redirect(w, r, "/"+url.PathEscape(c.spaceKey(c.DefaultSpace)), http.StatusFound)
+		return
+	}
+
+	switch {
+	case c.HomeFile != "":
+		c.viewHomeFile(w, r)
+	case c.HomeSpaceKey != "" && c.HomePageTitle != "":
+		c.viewHomePage(w, r)
+	default:
+		c.viewSpaceList(w, r)
+	}
+}
+
+func (c *Convergence) viewHomePage(w http.ResponseWriter, r *http.Request) {
 	var err error
 	var page *Page
 
+	if !c.spaceAllowed(c.HomeSpaceKey) {
+		c.showError(w, r, ErrNotFound)
+		return
+	}
+
 	if _, err := strconv.Atoi(c.HomePageTitle); err == nil {
-		page, err = c.confluence.GetPageByID(c.HomeSpaceKey, c.HomePageTitle)
+		page, err = c.confluence.GetPageByID(r.Context(), c.HomeSpaceKey, c.HomePageTitle)
 		if err != nil {
 			c.showError(w, r, err)
 			return
@@ -66,84 +340,692 @@ func (c *Convergence) viewRoot(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if page == nil {
-		page, err = c.confluence.GetPageByTitle(c.HomeSpaceKey, c.HomePageTitle)
+		page, err = c.confluence.GetPageByTitle(r.Context(), c.HomeSpaceKey, c.HomePageTitle)
 		if err != nil {
 			c.showError(w, r, err)
 			return
 		}
 	}
 
-	c.render.HTML(w, http.StatusOK, "index", map[string]interface{}{
-		"Title": page.Title,
-		"Body":  c.processBody(page.Body, c.HomeSpaceKey),
-	})
+	c.renderIndex(w, r, page.Title, c.processBody(page.Body))
+}
+
+type spaceEntry struct {
+	Key         string
+	Name        string
+	Homepage    string
+	Description template.HTML
+}
+
+var textPolicy = bluemonday.StrictPolicy()
+
+func (c *Convergence) spaceEntries(r *http.Request) ([]spaceEntry, error) {
+	spaces, err := c.confluence.GetSpaces(r.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []spaceEntry
+	for _, space := range spaces {
+		if !c.spaceAllowed(space.Key) {
+			continue
+		}
+
+		entry := spaceEntry{
+			Key:      space.Key,
+			Name:     space.Name,
+			Homepage: space.Homepage.Title,
+		}
+
+		// skip descriptions without any text
+		if strings.TrimSpace(textPolicy.Sanitize(space.Description)) != "" {
+			entry.Description = c.processBody(space.Description)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
 }
 
 func (c *Convergence) viewSpace(w http.ResponseWriter, r *http.Request) {
-	key := chi.URLParam(r, "key")
+	key := c.spaceKey(chi.URLParam(r, "key"))
+
+	if !c.spaceAllowed(key) {
+		c.showError(w, r, ErrNotFound)
+		return
+	}
 
-	space, err := c.confluence.GetSpace(key)
+	space, err := c.confluence.GetSpace(r.Context(), key)
 	if err != nil {
 		c.showError(w, r, err)
 		return
 	}
 
+	// list top level pages of spaces without homepage
+	if space.Homepage.ID == "" {
+		c.viewSpaceIndex(w, r, space)
+		return
+	}
+
+	if c.notModified(w, r, etag(space.Key, space.Name, space.Homepage.Body)) {
+		return
+	}
+
 	c.render.HTML(w, http.StatusOK, "page", map[string]interface{}{
 		"Title": space.Name,
-		"Body":  c.processBody(space.Homepage.Body, key),
+		"Body":  c.processBody(space.Homepage.Body),
 		"Index": key,
 		"Space": space.Name,
+
+		"FetchedAt": space.FetchedAt,
+		"CacheTTL":  c.confluence.cacheTTL("space-" + key),
 	})
 }
 
+func (c *Convergence) viewPages(w http.ResponseWriter, r *http.Request) {
+	key := c.spaceKey(chi.URLParam(r, "key"))
+
+	if !c.spaceAllowed(key) {
+		c.showError(w, r, ErrNotFound)
+		return
+	}
+
+	space, err := c.confluence.GetSpace(r.Context(), key)
+	if err != nil {
+		c.showError(w, r, err)
+		return
+	}
+
+	// sort by title unless requested otherwise
+	order := r.URL.Query().Get("sort")
+	if order != "updated" {
+		order = "title"
+	}
+
+	number, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || number < 1 {
+		number = 1
+	}
+
+	list, err := c.confluence.GetSpacePages(r.Context(), key, order, (number-1)*indexPageSize, indexPageSize)
+	if err != nil {
+		c.showError(w, r, err)
+		return
+	}
+
+	var prev, next int
+	if number > 1 {
+		prev = number - 1
+	}
+	if list.More {
+		next = number + 1
+	}
+
+	c.render.HTML(w, http.StatusOK, "pages", map[string]interface{}{
+		"Title": "Pages in " + space.Name,
+		"Index": key,
+		"Space": space.Name,
+		"Sort":  order,
+		"Pages": list.Pages,
+		"Prev":  prev,
+		"Next":  next,
+	})
+}
+
+func (c *Convergence) viewSpaceIndex(w http.ResponseWriter, r *http.Request, space *Space) {
+	pages, err := c.confluence.GetRootPages(r.Context(), space.Key)
+	if err != nil {
+		c.showError(w, r, err)
+		return
+	}
+
+	var body template.HTML
+	if len(pages) == 0 {
+		body = "<p><strong>This space has no homepage.</strong></p>"
+	}
+
+	c.render.HTML(w, http.StatusOK, "page", map[string]interface{}{
+		"Title":    space.Name,
+		"Body":     body,
+		"Index":    space.Key,
+		"Space":    space.Name,
+		"Children": pages,
+	})
+}
+
+func (c *Convergence) pageSpace(r *http.Request, key string) (*Space, error) {
+	space, err := c.confluence.GetSpace(r.Context(), key)
+	if err == nil {
+		return space, nil
+	}
+
+	// missing or forbidden spaces still fail the page
+	switch errorStatus(err) {
+	case http.StatusNotFound, http.StatusUnauthorized, http.StatusForbidden:
+		return nil, err
+	}
+
+	// the space only names the page, so render the content anyway
+	fmt.Printf("Space Error: %s\n", err.Error())
+	return &Space{Key: key, Name: key}, nil
+}
+
 func (c *Convergence) viewPage(w http.ResponseWriter, r *http.Request) {
-	key := chi.URLParam(r, "key")
+	key := c.spaceKey(chi.URLParam(r, "key"))
 	id := chi.URLParam(r, "id")
-	//title := chi.URLParam(r, "title")
+	title := chi.URLParam(r, "title")
 
 	var err error
 	var page *Page
 
-	space, err := c.confluence.GetSpace(key)
+	if !c.spaceAllowed(key) {
+		c.showError(w, r, ErrNotFound)
+		return
+	}
+
+	space, err := c.pageSpace(r, key)
 	if err != nil {
 		c.showError(w, r, err)
 		return
 	}
 
-	page, err = c.confluence.GetPageByID(key, id)
+	page, err = c.confluence.GetPageByID(r.Context(), key, id)
+	if err == nil && !c.spaceAllowed(page.SpaceKey) {
+		err = ErrNotFound
+	}
 	if err != nil {
 		c.showError(w, r, err)
 		return
 	}
 
+	// plain text is requested explicitly
+	if r.URL.Query().Get("format") == "text" {
+		c.viewText(w, r, page)
+		return
+	}
+
+	// serve markdown for .md titles or when asked for
+	w.Header().Add("Vary", "Accept")
+	if strings.HasSuffix(title, ".md") || acceptsMarkdown(r) {
+		c.viewMarkdown(w, r, page)
+		return
+	}
+
+	if strings.HasSuffix(title, ".pdf") {
+		c.viewPDF(w, r, space, page)
+		return
+	}
+
+	// children are optional
+	children, err := c.confluence.GetChildPages(r.Context(), page.ID)
+	if err != nil {
+		fmt.Printf("Children Error: %s\n", err.Error())
+	}
+
+	// labels are optional and may already be expanded
+	labels := page.Labels
+	if labels == nil {
+		labels, err = c.confluence.GetPageLabels(r.Context(), page.ID)
+		if err != nil {
+			fmt.Printf("Labels Error: %s\n", err.Error())
+		}
+	}
+
+	// comments are optional
+	comments, err := c.confluence.GetComments(r.Context(), page.ID)
+	if err != nil {
+		fmt.Printf("Comments Error: %s\n", err.Error())
+	}
+
+	// attachments are optional
+	attachments, err := c.confluence.GetAttachments(r.Context(), page.ID)
+	if err != nil {
+		fmt.Printf("Attachments Error: %s\n", err.Error())
+	}
+
+	// the author picture is optional
+	var avatar string
+	if page.UpdatedByID != "" {
+		user, err := c.confluence.GetUser(r.Context(), page.UpdatedByID)
+		if err != nil {
+			fmt.Printf("User Error: %s\n", err.Error())
+		} else {
+			avatar = user.ProfilePicture
+		}
+	}
+
+	// derive tag from everything rendered
+	parts := []string{space.Name, page.ID, page.Title, page.Body, strconv.Itoa(page.Version), avatar}
+	for _, related := range append(page.Ancestors, children...) {
+		parts = append(parts, related.ID, related.Title)
+	}
+	parts = append(parts, labels...)
+	for _, comment := range comments {
+		parts = append(parts, comment.ID, comment.Body)
+	}
+	for _, attachment := range attachments {
+		parts = append(parts, attachment.ID, attachment.Filename, strconv.Itoa(attachment.Version))
+	}
+
+	// count views including revalidations
+	c.views.record(key, page, c.TopPagesWindow)
+
+	if c.notModified(w, r, etag(parts...)) {
+		return
+	}
+
 	c.render.HTML(w, http.StatusOK, "page", map[string]interface{}{
-		"Title": page.Title,
-		"Body":  c.processBody(page.Body, key),
+		"ID":          page.ID,
+		"Title":       page.Title,
+		"Body":        c.processBody(page.Body),
+		"Index":       key,
+		"Space":       space.Name,
+		"Ancestors":   page.Ancestors,
+		"Children":    children,
+		"Labels":      labels,
+		"Comments":    comments,
+		"Attachments": attachments,
+		"UpdatedBy":   page.UpdatedBy,
+		"UpdatedAt":   page.UpdatedAt,
+		"Avatar":      avatar,
+		"TOC":         sidebarTOC(page),
+		"FetchedAt":   page.FetchedAt,
+		"CacheTTL":    c.confluence.cacheTTL("page-" + key),
+	})
+}
+
+func (c *Convergence) viewPrint(w http.ResponseWriter, r *http.Request) {
+	key := c.spaceKey(chi.URLParam(r, "key"))
+	id := chi.URLParam(r, "id")
+
+	if !c.spaceAllowed(key) {
+		c.showError(w, r, ErrNotFound)
+		return
+	}
+
+	space, err := c.pageSpace(r, key)
+	if err != nil {
+		c.showError(w, r, err)
+		return
+	}
+
+	page, err := c.confluence.GetPageByID(r.Context(), key, id)
+	if err == nil && !c.spaceAllowed(page.SpaceKey) {
+		err = ErrNotFound
+	}
+	if err != nil {
+		c.showError(w, r, err)
+		return
+	}
+
+	if c.notModified(w, r, etag("print", space.Name, page.ID, page.Title, page.Body, strconv.Itoa(page.Version))) {
+		return
+	}
+
+	// render without navigation
+	c.render.HTML(w, http.StatusOK, "print", c.printBinding(space, page), render.HTMLOptions{})
+}
+
+func (c *Convergence) printBinding(space *Space, page *Page) map[string]interface{} {
+	return map[string]interface{}{
+		"Title":     page.Title,
+		"Body":      c.processBody(page.Body),
+		"Space":     space.Name,
+		"UpdatedBy": page.UpdatedBy,
+		"UpdatedAt": page.UpdatedAt,
+	}
+}
+
+func (c *Convergence) viewBlog(w http.ResponseWriter, r *http.Request) {
+	key := c.spaceKey(chi.URLParam(r, "key"))
+
+	if !c.spaceAllowed(key) {
+		c.showError(w, r, ErrNotFound)
+		return
+	}
+
+	space, err := c.pageSpace(r, key)
+	if err != nil {
+		c.showError(w, r, err)
+		return
+	}
+
+	posts, err := c.confluence.GetBlogPosts(r.Context(), key)
+	if err != nil {
+		c.showError(w, r, err)
+		return
+	}
+
+	c.render.HTML(w, http.StatusOK, "blog", map[string]interface{}{
+		"Title": space.Name + " Blog",
 		"Index": key,
 		"Space": space.Name,
+		"Posts": posts,
+	})
+}
+
+func (c *Convergence) viewBlogPost(w http.ResponseWriter, r *http.Request) {
+	key := c.spaceKey(chi.URLParam(r, "key"))
+	id := chi.URLParam(r, "id")
+
+	if !c.spaceAllowed(key) {
+		c.showError(w, r, ErrNotFound)
+		return
+	}
+
+	space, err := c.pageSpace(r, key)
+	if err != nil {
+		c.showError(w, r, err)
+		return
+	}
+
+	post, err := c.confluence.GetBlogPostByID(r.Context(), key, id)
+	if err == nil && !c.spaceAllowed(post.SpaceKey) {
+		err = ErrNotFound
+	}
+	if err != nil {
+		c.showError(w, r, err)
+		return
+	}
+
+	if c.notModified(w, r, etag(space.Name, post.ID, post.Title, post.Body, strconv.Itoa(post.Version))) {
+		return
+	}
+
+	c.render.HTML(w, http.StatusOK, "page", map[string]interface{}{
+		"Title":     post.Title,
+		"Body":      c.processBody(post.Body),
+		"Index":     key,
+		"Space":     space.Name,
+		"UpdatedBy": post.UpdatedBy,
+		"UpdatedAt": post.UpdatedAt,
+		"FetchedAt": post.FetchedAt,
+		"CacheTTL":  c.confluence.cacheTTL("blogpost-" + key),
+	})
+}
+
+func (c *Convergence) viewDisplay(w http.ResponseWriter, r *http.Request) {
+	key := c.spaceKey(chi.URLParam(r, "key"))
+	title := decodeTitle(chi.URLParam(r, "title"))
+
+	if !c.spaceAllowed(key) {
+		c.showError(w, r, ErrNotFound)
+		return
+	}
+
+	page, err := c.confluence.GetPageByTitle(r.Context(), key, title)
+	if err != nil {
+		c.showError(w, r, err)
+		return
+	}
+
+	// redirect to canonical page url
+	http.Redirect(w, r, "/"+key+"/"+page.ID+"/"+url.QueryEscape(page.Title), http.StatusFound)
+}
+
+var excerptPolicy = bluemonday.NewPolicy().AllowElements("mark")
+
+func (c *Convergence) viewSearch(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	key := c.spaceKey(r.URL.Query().Get("space"))
+
+	if key != "" && !c.spaceAllowed(key) {
+		c.showError(w, r, ErrNotFound)
+		return
+	}
+
+	number, size := pagination(r)
+
+	var results []*Page
+	var prev, next int
+
+	// only search with a query
+	if query != "" {
+		cql := `type = page and text ~ ` + quoteCQL(query)
+		if key != "" {
+			cql += ` and space = ` + quoteCQL(key)
+		}
+
+		list, err := c.confluence.SearchPage(r.Context(), cql, (number-1)*size, size)
+		if err != nil {
+			c.showError(w, r, err)
+			return
+		}
+
+		results = c.filterPages(list.Pages)
+
+		if number > 1 {
+			prev = number - 1
+		}
+		if list.More {
+			next = number + 1
+		}
+	}
+
+	c.render.HTML(w, http.StatusOK, "search", map[string]interface{}{
+		"Title":   "Search",
+		"Query":   query,
+		"Key":     key,
+		"Results": results,
+		"Size":    size,
+		"Prev":    prev,
+		"Next":    next,
 	})
 }
 
-func (c *Convergence) handleReset(w http.ResponseWriter, r *http.Request) {
-	c.confluence.Reset()
+func (c *Convergence) viewLabel(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	key := c.spaceKey(r.URL.Query().Get("space"))
+
+	if key != "" && !c.spaceAllowed(key) {
+		c.showError(w, r, ErrNotFound)
+		return
+	}
+
+	number, size := pagination(r)
+
+	list, err := c.confluence.GetPagesByLabel(r.Context(), name, key, (number-1)*size, size)
+	if err != nil {
+		c.showError(w, r, err)
+		return
+	}
+
+	var prev, next int
+	if number > 1 {
+		prev = number - 1
+	}
+	if list.More {
+		next = number + 1
+	}
+
+	c.render.HTML(w, http.StatusOK, "label", map[string]interface{}{
+		"Title":   name,
+		"Key":     key,
+		"Results": c.filterPages(list.Pages),
+		"Size":    size,
+		"Prev":    prev,
+		"Next":    next,
+	})
+}
+
+func (c *Convergence) viewAttachment(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	file := chi.URLParam(r, "file")
+	query := r.URL.Query()
+	version := query.Get("version")
+	date := query.Get("modificationDate")
+
+	// attachments belong to the space of their page
+	if c.restricted() && !c.pageAllowed(r.Context(), id) {
+		c.showError(w, r, ErrNotFound)
+		return
+	}
+
+	modified, hasModified := parseModificationDate(date)
+	if hasModified {
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+
+	// versioned attachments never change
+	if version != "" && c.notModified(w, r, etag(id, file, version, date)) {
+		return
+	}
+
+	if hasModified && notModifiedSince(w, r, modified) {
+		return
+	}
+
+	attachment, err := c.confluence.GetAttachment(r.Context(), id, file, version, date, query.Get("api"))
+	if errors.Is(err, ErrTooLarge) {
+		c.streamAttachment(w, r, id, file, version, date, query.Get("api"))
+		return
+	}
+	if err != nil {
+		c.showError(w, r, err)
+		return
+	}
+
+	// otherwise derive tag from content
+	if version == "" && c.notModified(w, r, etag(id, file, string(attachment.Data))) {
+		return
+	}
+
+	w.Header().Set("Content-Type", attachment.ContentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(attachment.Data)
+}
+
+func (c *Convergence) streamAttachment(w http.ResponseWriter, r *http.Request, id, file, version, date, api string) {
+	body, contentType, err := c.confluence.StreamAttachment(r.Context(), id, file, version, date, api)
+	if err != nil {
+		c.showError(w, r, err)
+		return
+	}
+
+	defer body.Close()
+
+	if contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := io.Copy(w, body); err != nil {
+		fmt.Printf("Stream Error: %s\n", err.Error())
+	}
+}
+
+func (c *Convergence) handleInvalidate(w http.ResponseWriter, r *http.Request) {
+	key := r.FormValue("key")
+	id := r.FormValue("id")
 
-	referrer := r.Referer()
-	if len(referrer) <= 0 {
-		referrer = "/"
+	// read confluence webhook payload
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if json, err := gabs.ParseJSONBuffer(r.Body); err == nil {
+			key, _ = json.Path("page.spaceKey").Data().(string)
+
+			// ids may be sent as numbers
+			switch value := json.Path("page.id").Data().(type) {
+			case string:
+				id = value
+			case float64:
+				id = strconv.FormatFloat(value, 'f', -1, 64)
+			}
+		}
+	}
+
+	switch {
+	case key != "" && id != "":
+		c.confluence.InvalidatePage(key, id)
+	case key != "":
+		c.confluence.InvalidateSpace(key)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *Convergence) handleWarm(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	var keys []string
+	for _, key := range r.Form["key"] {
+		keys = append(keys, c.spaceKey(key))
+	}
+
+	count, err := c.confluence.Warm(r.Context(), keys, c.spaceAllowed)
+
+	result := map[string]interface{}{
+		"warmed": count,
+	}
+	if err != nil {
+		result["error"] = err.Error()
 	}
 
-	http.Redirect(w, r, referrer, http.StatusTemporaryRedirect)
+	c.render.JSON(w, http.StatusOK, result)
+}
+
+func (c *Convergence) handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok"))
+}
+
+func (c *Convergence) handleReady(w http.ResponseWriter, r *http.Request) {
+	c.readyMutex.Lock()
+	defer c.readyMutex.Unlock()
+
+	// probe confluence if the last result is outdated
+	if time.Since(c.readyTime) > c.ReadyInterval {
+		ctx, cancel := context.WithTimeout(r.Context(), c.ReadyTimeout)
+		defer cancel()
+
+		c.readyError = c.confluence.Ping(ctx)
+		c.readyTime = time.Now()
+	}
+
+	if c.readyError != nil {
+		fmt.Printf("Not Ready: %s\n", c.readyError.Error())
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("not ready"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok"))
 }
 
 func (c *Convergence) handleNotFound(w http.ResponseWriter, r *http.Request) {
 	c.showError(w, r, ErrNotFound)
 }
 
+func (c *Convergence) slashMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.EscapedPath()
+
+		// redirect to the canonical path without trailing slashes
+		if (r.Method == "GET" || r.Method == "HEAD") && len(path) > 1 && strings.HasSuffix(path, "/") && !strings.HasPrefix(path, "/wiki/") {
+			// never redirect to another host
+			target := "/" + strings.Trim(path, "/")
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+
+			http.Redirect(w, r, target, http.StatusFound)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (c *Convergence) proxyMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// proxy request if begins with /wiki
 		if strings.HasPrefix(r.URL.Path, "/wiki") {
-			c.proxy.ServeHTTP(w, r)
+			c.limit(c.serveProxy)(w, r)
 			return
 		}
 
@@ -151,33 +1033,355 @@ func (c *Convergence) proxyMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+func (c *Convergence) serveProxy(w http.ResponseWriter, r *http.Request) {
+	// proxied content must belong to an allowed space
+	if c.restricted() && !c.proxyAllowed(r) {
+		c.showError(w, r, ErrNotFound)
+		return
+	}
+
+	c.proxy.ServeHTTP(w, r)
+}
+
+var proxyPagePattern = regexp.MustCompile(`^/wiki/download/(?:attachments|thumbnails)/(\d+)(?:/|$)`)
+
+var proxySpacePattern = regexp.MustCompile(`^/wiki/(?:spaces|display)/([^/]+)(?:/|$)`)
+
+var proxyTinyPattern = regexp.MustCompile(`^/wiki/x/([A-Za-z0-9_-]+)`)
+
+var proxyStaticPrefixes = []string{"/wiki/s/", "/wiki/images/", "/wiki/download/resources/"}
+
+func (c *Convergence) proxyAllowed(r *http.Request) bool {
+	path := r.URL.Path
+
+	// the rest api would bypass space restrictions
+	if strings.HasPrefix(path, "/wiki/rest") {
+		return false
+	}
+
+	// static resources are not part of any space
+	for _, prefix := range proxyStaticPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+
+	if id := r.URL.Query().Get("pageId"); id != "" {
+		return c.pageAllowed(r.Context(), id)
+	}
+
+	if match := proxyPagePattern.FindStringSubmatch(path); match != nil {
+		return c.pageAllowed(r.Context(), match[1])
+	}
+
+	if match := proxySpacePattern.FindStringSubmatch(path); match != nil {
+		return c.spaceAllowed(match[1])
+	}
+
+	if match := proxyTinyPattern.FindStringSubmatch(path); match != nil {
+		page, err := c.confluence.ResolveTinyLink(r.Context(), match[1])
+		return err == nil && page.SpaceKey != "" && c.spaceAllowed(page.SpaceKey)
+	}
+
+	// deny anything that cannot be attributed to a space
+	return false
+}
+
+func (c *Convergence) pageAllowed(ctx context.Context, id string) bool {
+	page, err := c.confluence.GetPageRef(ctx, id)
+	if err != nil {
+		return false
+	}
+
+	return page.SpaceKey != "" && c.spaceAllowed(page.SpaceKey)
+}
+
 func (c *Convergence) showError(w http.ResponseWriter, r *http.Request, err error) {
-	// check if not found
-	if err == ErrNotFound {
+	status := errorStatus(err)
+	setRetryAfter(w, err)
+
+	switch status {
+	case http.StatusNotFound:
 		fmt.Printf("Not Found: %s\n", r.URL.String())
-		c.render.HTML(w, http.StatusNotFound, "404", map[string]interface{}{
+		c.render.HTML(w, status, c.NotFoundTemplate, map[string]interface{}{
 			"Title": "Not Found",
 		})
+	case http.StatusUnauthorized, http.StatusForbidden:
+		fmt.Printf("Not Authorized: %s\n", r.URL.String())
+		c.render.HTML(w, status, c.AuthTemplate, map[string]interface{}{
+			"Title": "Not Authorized",
+		})
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		fmt.Printf("Upstream Error: %s\n", err.Error())
+		c.render.HTML(w, status, c.UpstreamTemplate, map[string]interface{}{
+			"Title": http.StatusText(status),
+		})
+	default:
+		fmt.Printf("Internal Error: %s\n", err.Error())
+		c.render.HTML(w, status, c.ErrorTemplate, map[string]interface{}{
+			"Title": "Internal Server Error",
+		})
+	}
+}
 
-		return
+func setRetryAfter(w http.ResponseWriter, err error) {
+	// pass on how long confluence asked to wait
+	var limitErr RateLimitError
+	if errors.As(err, &limitErr) && limitErr.RetryAfter > 0 {
+		seconds := int((limitErr.RetryAfter + time.Second - 1) / time.Second)
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
 	}
+}
 
-	// internal server error
-	fmt.Printf("Internal Error: %s\n", err.Error())
-	c.render.HTML(w, http.StatusInternalServerError, "503", map[string]interface{}{
-		"Title": "Internal Server Error",
-	})
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrUnauthorized):
+		return http.StatusUnauthorized
+	case errors.Is(err, ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, ErrEmptyResponse), errors.Is(err, ErrUnexpectedResponse), errors.Is(err, context.DeadlineExceeded):
+		return http.StatusBadGateway
+	}
+
+	// confluence asked to slow down
+	var limitErr RateLimitError
+	if errors.As(err, &limitErr) {
+		return http.StatusServiceUnavailable
+	}
+
+	// confluence answered with an unexpected status
+	var statusErr StatusError
+	if errors.As(err, &statusErr) {
+		if statusErr.Code == http.StatusServiceUnavailable {
+			return http.StatusServiceUnavailable
+		}
+
+		return http.StatusBadGateway
+	}
+
+	// confluence could not be reached or sent garbage
+	var requestErr RequestError
+	var parseErr ParseError
+	var netErr net.Error
+	if errors.As(err, &requestErr) || errors.As(err, &parseErr) || errors.As(err, &netErr) {
+		return http.StatusBadGateway
+	}
+
+	return http.StatusInternalServerError
+}
+
+const searchPageSize = 25
+
+const maxSearchPageSize = 100
+
+const indexPageSize = 50
+
+func pagination(r *http.Request) (int, int) {
+	number, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || number < 1 {
+		number = 1
+	}
+
+	// keep result pages reasonably small
+	size, err := strconv.Atoi(r.URL.Query().Get("size"))
+	if err != nil || size < 1 {
+		size = searchPageSize
+	}
+	if size > maxSearchPageSize {
+		size = maxSearchPageSize
+	}
+
+	return number, size
 }
 
-var linkRegex = regexp.MustCompile(`"/wiki/spaces/([A-z0-9]+)/pages/([0-9]+)/?(\S*)"`)
+func (c *Convergence) spaceAllowed(key string) bool {
+	// denied spaces always lose
+	for _, denied := range c.DeniedSpaces {
+		if strings.EqualFold(denied, key) {
+			return false
+		}
+	}
 
-func (c *Convergence) processBody(body string, key string) template.HTML {
-	for _, match := range linkRegex.FindAllStringSubmatch(body, -1) {
-		body = strings.Replace(body, match[0], `"/`+key+`/`+match[2]+`/`+match[3]+`"`, 1)
+	// an empty allowlist allows all spaces
+	if len(c.AllowedSpaces) == 0 {
+		return true
 	}
 
-	body = strings.Replace(body, "/wiki/display/", "/", -1)
-	body = strings.Replace(body, "/wiki/spaces/", "/", -1)
+	for _, allowed := range c.AllowedSpaces {
+		if strings.EqualFold(allowed, key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *Convergence) restricted() bool {
+	return len(c.AllowedSpaces) > 0 || len(c.DeniedSpaces) > 0
+}
+
+func (c *Convergence) filterPages(pages []*Page) []*Page {
+	var filtered []*Page
+	for _, page := range pages {
+		if c.spaceAllowed(page.SpaceKey) {
+			filtered = append(filtered, page)
+		}
+	}
+
+	return filtered
+}
+
+func (c *Convergence) spaceKey(key string) string {
+	// personal space keys keep their case
+	if c.UppercaseKeys && !strings.HasPrefix(key, "~") {
+		return strings.ToUpper(key)
+	}
+
+	return key
+}
+
+func decodeTitle(title string) string {
+	// confluence links encode spaces as plus
+	return strings.Replace(title, "+", " ", -1)
+}
+
+func requestBase(r *http.Request) string {
+	// absolute links use the requested host
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		return "https://" + r.Host
+	}
+
+	return "http://" + r.Host
+}
+
+func formatSize(size int64) string {
+	// use binary units up to gigabytes
+	units := []string{"B", "KB", "MB", "GB"}
+	value := float64(size)
+
+	i := 0
+	for value >= 1024 && i < len(units)-1 {
+		value /= 1024
+		i++
+	}
+
+	if i == 0 {
+		return strconv.FormatInt(size, 10) + " B"
+	}
+
+	return strconv.FormatFloat(value, 'f', 1, 64) + " " + units[i]
+}
+
+func formatAge(t time.Time) string {
+	// round down to whole minutes and hours
+	age := time.Since(t)
+
+	switch {
+	case age < time.Minute:
+		return "just now"
+	case age < 2*time.Minute:
+		return "1 minute ago"
+	case age < time.Hour:
+		return strconv.Itoa(int(age/time.Minute)) + " minutes ago"
+	case age < 2*time.Hour:
+		return "1 hour ago"
+	}
+
+	return strconv.Itoa(int(age/time.Hour)) + " hours ago"
+}
+
+func formatDuration(d time.Duration) string {
+	// prefer whole units over go notation
+	switch {
+	case d == time.Hour:
+		return "hour"
+	case d > time.Hour && d%time.Hour == 0:
+		return strconv.Itoa(int(d/time.Hour)) + " hours"
+	case d == time.Minute:
+		return "minute"
+	case d > time.Minute && d%time.Minute == 0:
+		return strconv.Itoa(int(d/time.Minute)) + " minutes"
+	}
+
+	return d.String()
+}
+
+func etag(parts ...string) string {
+	hash := fnv.New64a()
+	for _, part := range parts {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+
+	return `"` + strconv.FormatUint(hash.Sum64(), 16) + `"`
+}
+
+func (c *Convergence) notModified(w http.ResponseWriter, r *http.Request, tag string) bool {
+	// allow clients to keep pages for a while
+	w.Header().Set("Cache-Control", cacheControl(c.PageMaxAge))
+
+	return notModified(w, r, tag)
+}
+
+func notModified(w http.ResponseWriter, r *http.Request, tag string) bool {
+	w.Header().Set("ETag", tag)
+
+	// check all provided tags
+	for _, match := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		match = strings.TrimPrefix(strings.TrimSpace(match), "W/")
+		if match == tag || match == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+
+	return false
+}
+
+func notModifiedSince(w http.ResponseWriter, r *http.Request, modified time.Time) bool {
+	// entity tags take precedence over dates
+	if r.Header.Get("If-None-Match") != "" {
+		return false
+	}
+
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+
+	// http dates have second precision
+	if modified.Truncate(time.Second).After(since) {
+		return false
+	}
+
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+func parseModificationDate(date string) (time.Time, bool) {
+	if date == "" {
+		return time.Time{}, false
+	}
+
+	// confluence passes epoch milliseconds
+	if millis, err := strconv.ParseInt(date, 10, 64); err == nil {
+		return time.Unix(0, millis*int64(time.Millisecond)), true
+	}
+
+	if t, err := time.Parse(time.RFC3339, date); err == nil {
+		return t, true
+	}
+
+	return time.Time{}, false
+}
+
+func (c *Convergence) processBody(body string) template.HTML {
+	return template.HTML(rewriteLinks(body, c.LinkRules))
+}
 
-	return template.HTML(body)
+func (c *Convergence) processExcerpt(excerpt string) template.HTML {
+	// only allow highlights
+	return template.HTML(excerptPolicy.Sanitize(excerpt))
 }
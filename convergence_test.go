@@ -0,0 +1,227 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pressly/chi"
+)
+
+func newTestConfluence(t *testing.T, handler http.HandlerFunc) *Confluence {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	c := NewConfluence(server.URL, "user", "secret")
+	c.RetryBackoff = time.Millisecond
+
+	return c
+}
+
+func spacesHandler(w http.ResponseWriter, r *http.Request) {
+	// pages 1 and 2 live in different spaces
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.URL.Path {
+	case "/wiki/rest/api/content/1":
+		w.Write([]byte(`{"id":"1","type":"page","title":"Public","space":{"key":"ENG"}}`))
+	case "/wiki/rest/api/content/2":
+		w.Write([]byte(`{"id":"2","type":"page","title":"Secret","space":{"key":"INTERNAL"}}`))
+	case "/wiki/download/attachments/1/a.txt", "/wiki/download/attachments/2/a.txt":
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("data"))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"statusCode":404}`))
+	}
+}
+
+func TestSpaceAllowed(t *testing.T) {
+	tests := []struct {
+		allowed []string
+		denied  []string
+		key     string
+		want    bool
+	}{
+		{nil, nil, "ENG", true},
+		{[]string{"ENG"}, nil, "ENG", true},
+		{[]string{"ENG"}, nil, "eng", true},
+		{[]string{"ENG"}, nil, "INTERNAL", false},
+		{nil, []string{"INTERNAL"}, "INTERNAL", false},
+		{nil, []string{"INTERNAL"}, "ENG", true},
+		{[]string{"ENG", "INTERNAL"}, []string{"internal"}, "INTERNAL", false},
+	}
+
+	for _, test := range tests {
+		c := &Convergence{AllowedSpaces: test.allowed, DeniedSpaces: test.denied}
+		if got := c.spaceAllowed(test.key); got != test.want {
+			t.Errorf("spaceAllowed(%q) with allowed %v and denied %v = %v; want %v", test.key, test.allowed, test.denied, got, test.want)
+		}
+	}
+}
+
+func TestProxyAllowed(t *testing.T) {
+	c := NewConvergence(newTestConfluence(t, spacesHandler), "", "")
+	c.DeniedSpaces = []string{"INTERNAL"}
+
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/wiki/rest/api/content/1", false},
+		{"/wiki/s/123/_/styles/main.css", true},
+		{"/wiki/images/icons/emoticons/smile.png", true},
+		{"/wiki/spaces/ENG/pages/1/Public", true},
+		{"/wiki/spaces/INTERNAL/pages/2/Secret", false},
+		{"/wiki/display/INTERNAL/Secret", false},
+		{"/wiki/display/ENG/Public", true},
+		{"/wiki/pages/viewpage.action?pageId=1", true},
+		{"/wiki/pages/viewpage.action?pageId=2", false},
+		{"/wiki/pages/viewpage.action?pageId=3", false},
+		{"/wiki/download/attachments/1/a.txt", true},
+		{"/wiki/download/attachments/2/a.txt", false},
+		{"/wiki/download/thumbnails/2/a.png", false},
+		{"/wiki/x/AQAAAA", true},
+		{"/wiki/x/AgAAAA", false},
+		{"/wiki/admin/users.action", false},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", test.path, nil)
+		if got := c.proxyAllowed(r); got != test.want {
+			t.Errorf("proxyAllowed(%q) = %v; want %v", test.path, got, test.want)
+		}
+	}
+}
+
+func TestViewAttachmentRestricted(t *testing.T) {
+	c := NewConvergence(newTestConfluence(t, spacesHandler), "", "")
+	c.DeniedSpaces = []string{"INTERNAL"}
+
+	router := chi.NewRouter()
+	router.Get("/download/attachments/:id/:file", c.viewAttachment)
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/download/attachments/1/a.txt", http.StatusOK},
+		{"/download/attachments/2/a.txt", http.StatusNotFound},
+		{"/download/attachments/3/a.txt", http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("GET", test.path, nil))
+
+		if rec.Code != test.status {
+			t.Errorf("GET %s = %d; want %d", test.path, rec.Code, test.status)
+		}
+		if test.status == http.StatusOK && !strings.Contains(rec.Body.String(), "data") {
+			t.Errorf("GET %s = %q; want attachment data", test.path, rec.Body.String())
+		}
+	}
+}
+
+func TestDecodeTitle(t *testing.T) {
+	tests := []struct {
+		title string
+		want  string
+	}{
+		{"Home", "Home"},
+		{"Some+Page", "Some Page"},
+		{"Release 1.0 & Beyond", "Release 1.0 & Beyond"},
+		{"A+B+C", "A B C"},
+	}
+
+	for _, test := range tests {
+		if got := decodeTitle(test.title); got != test.want {
+			t.Errorf("decodeTitle(%q) = %q; want %q", test.title, got, test.want)
+		}
+	}
+}
+
+func TestSlashMiddleware(t *testing.T) {
+	c := &Convergence{}
+
+	handler := c.slashMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	}))
+
+	tests := []struct {
+		path     string
+		status   int
+		location string
+	}{
+		{"/page/foo", http.StatusOK, ""},
+		{"/page/foo/", http.StatusFound, "/page/foo"},
+		{"/page/FOO", http.StatusOK, ""},
+		{"/page/foo//", http.StatusFound, "/page/foo"},
+		{"/page/foo/?q=1", http.StatusFound, "/page/foo?q=1"},
+		{"//evil.com/", http.StatusFound, "/evil.com"},
+		{"/wiki/spaces/ENG/", http.StatusOK, ""},
+		{"/", http.StatusOK, ""},
+	}
+
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", test.path, nil))
+
+		if rec.Code != test.status || rec.Header().Get("Location") != test.location {
+			t.Errorf("GET %s = %d %q; want %d %q", test.path, rec.Code, rec.Header().Get("Location"), test.status, test.location)
+		}
+	}
+}
+
+func TestSpaceKey(t *testing.T) {
+	tests := []struct {
+		uppercase bool
+		key       string
+		want      string
+	}{
+		{false, "foo", "foo"},
+		{false, "FOO", "FOO"},
+		{true, "foo", "FOO"},
+		{true, "FOO", "FOO"},
+		{true, "~jdoe", "~jdoe"},
+	}
+
+	for _, test := range tests {
+		c := &Convergence{UppercaseKeys: test.uppercase}
+		if got := c.spaceKey(test.key); got != test.want {
+			t.Errorf("spaceKey(%q) with UppercaseKeys %v = %q; want %q", test.key, test.uppercase, got, test.want)
+		}
+	}
+}
+
+func TestDefaultSpace(t *testing.T) {
+	tests := []struct {
+		space     string
+		uppercase bool
+		status    int
+		location  string
+	}{
+		{"ENG", false, http.StatusFound, "/ENG"},
+		{"eng", true, http.StatusFound, "/ENG"},
+		{"", false, http.StatusOK, ""},
+	}
+
+	for _, test := range tests {
+		c := NewConvergence(newTestConfluence(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"results":[{"key":"ENG","name":"Engineering"}],"size":1}`))
+		}), "", "")
+
+		c.DefaultSpace = test.space
+		c.UppercaseKeys = test.uppercase
+
+		rec := httptest.NewRecorder()
+		c.viewRoot(rec, httptest.NewRequest("GET", "/", nil))
+
+		if rec.Code != test.status || rec.Header().Get("Location") != test.location {
+			t.Errorf("DefaultSpace %q: GET / = %d %q; want %d %q", test.space, rec.Code, rec.Header().Get("Location"), test.status, test.location)
+		}
+	}
+}
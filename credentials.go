@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+type Credentials struct {
+	Username string
+	Password string
+	Token    string
+}
+
+func (c Credentials) authorization() string {
+	if c.Token != "" {
+		return "Bearer " + c.Token
+	}
+
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(c.Username+":"+c.Password))
+}
+
+func ParseSpaceCredentials(value string) (map[string]Credentials, error) {
+	credentials := make(map[string]Credentials)
+
+	// entries look like KEY=username:password or KEY=token
+	for i, item := range splitList(value) {
+		// never echo the entry as it may contain a secret
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, errors.New("invalid space credentials: entry " + strconv.Itoa(i+1))
+		}
+
+		if login := strings.SplitN(parts[1], ":", 2); len(login) == 2 {
+			credentials[parts[0]] = Credentials{Username: login[0], Password: login[1]}
+		} else {
+			credentials[parts[0]] = Credentials{Token: parts[1]}
+		}
+	}
+
+	return credentials, nil
+}
+
+type spaceContextKey struct{}
+
+func withSpace(ctx context.Context, key string) context.Context {
+	if key == "" {
+		return ctx
+	}
+
+	return context.WithValue(ctx, spaceContextKey{}, key)
+}
+
+func (c *Confluence) withPageSpace(ctx context.Context, id string) context.Context {
+	// keep an explicitly selected space
+	if _, ok := ctx.Value(spaceContextKey{}).(string); ok {
+		return ctx
+	}
+
+	// otherwise use the space the page was seen in
+	if key, ok := c.pageSpaces.Load(id); ok {
+		return withSpace(ctx, key.(string))
+	}
+
+	return ctx
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseSpaceCredentials(t *testing.T) {
+	tests := []struct {
+		value string
+		want  map[string]Credentials
+		err   string
+	}{
+		{"", map[string]Credentials{}, ""},
+		{"ENG=user:pass", map[string]Credentials{"ENG": {Username: "user", Password: "pass"}}, ""},
+		{"ENG=token,HR=a:b", map[string]Credentials{"ENG": {Token: "token"}, "HR": {Username: "a", Password: "b"}}, ""},
+		{"ENG:user:s3cret", nil, "invalid space credentials: entry 1"},
+		{"ENG=token,HR=", nil, "invalid space credentials: entry 2"},
+		{"=user:s3cret", nil, "invalid space credentials: entry 1"},
+	}
+
+	for _, test := range tests {
+		got, err := ParseSpaceCredentials(test.value)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("ParseSpaceCredentials(%q) error = %v; want %q", test.value, err, test.err)
+			}
+			if err != nil && strings.Contains(err.Error(), "s3cret") {
+				t.Errorf("ParseSpaceCredentials(%q) error %q contains the secret", test.value, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("ParseSpaceCredentials(%q) error = %v", test.value, err)
+			continue
+		}
+		if len(got) != len(test.want) {
+			t.Errorf("ParseSpaceCredentials(%q) = %v; want %v", test.value, got, test.want)
+		}
+		for key, credentials := range test.want {
+			if got[key] != credentials {
+				t.Errorf("ParseSpaceCredentials(%q)[%q] = %v; want %v", test.value, key, got[key], credentials)
+			}
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"embed"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed templates assets
+var embedded embed.FS
+
+type templateSource struct {
+	dir string
+}
+
+func (s templateSource) Asset(name string) ([]byte, error) {
+	// prefer templates from the override directory
+	if s.dir != "" {
+		data, err := ioutil.ReadFile(filepath.Join(s.dir, strings.TrimPrefix(name, "templates/")))
+		if err == nil {
+			return data, nil
+		}
+	}
+
+	return embedded.ReadFile(name)
+}
+
+func (s templateSource) AssetNames() []string {
+	seen := make(map[string]bool)
+	var names []string
+
+	fs.WalkDir(embedded, "templates", func(path string, entry fs.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() {
+			seen[path] = true
+			names = append(names, path)
+		}
+
+		return err
+	})
+
+	// add templates that only exist in the override directory
+	if s.dir != "" {
+		filepath.Walk(s.dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return nil
+			}
+
+			rel, err := filepath.Rel(s.dir, path)
+			if err != nil {
+				return nil
+			}
+
+			name := "templates/" + filepath.ToSlash(rel)
+			if !seen[name] {
+				names = append(names, name)
+			}
+
+			return nil
+		})
+	}
+
+	return names
+}
+
+type overlayFileSystem struct {
+	primary  http.FileSystem
+	fallback http.FileSystem
+}
+
+func (o overlayFileSystem) Open(name string) (http.File, error) {
+	// prefer files from the override directory
+	if file, err := o.primary.Open(name); err == nil {
+		return file, nil
+	}
+
+	return o.fallback.Open(name)
+}
+
+func assetFileSystem(dir string) http.FileSystem {
+	assets, err := fs.Sub(embedded, "assets")
+	if err != nil {
+		panic(err)
+	}
+
+	if dir == "" {
+		return http.FS(assets)
+	}
+
+	return overlayFileSystem{primary: http.Dir(dir), fallback: http.FS(assets)}
+}
+
+type assetTag struct {
+	modTime time.Time
+	size    int64
+	tag     string
+}
+
+func (c *Convergence) assetMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// only assets are cached aggressively
+		if !strings.HasPrefix(r.URL.Path, "/assets/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// tag assets by content, the file server answers conditional requests
+		if tag, ok := c.assetTag(strings.TrimPrefix(r.URL.Path, "/assets")); ok {
+			w.Header().Set("ETag", tag)
+			w.Header().Set("Cache-Control", cacheControl(c.AssetMaxAge))
+
+			if c.AssetMaxAge > 0 {
+				w.Header().Set("Expires", time.Now().Add(c.AssetMaxAge).UTC().Format(http.TimeFormat))
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (c *Convergence) assetTag(name string) (string, bool) {
+	name = path.Clean("/" + name)
+
+	file, err := c.assets.Open(name)
+	if err != nil {
+		return "", false
+	}
+
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		return "", false
+	}
+
+	// reuse tags of unchanged files
+	if value, ok := c.assetTags.Load(name); ok {
+		entry := value.(assetTag)
+		if entry.modTime.Equal(info.ModTime()) && entry.size == info.Size() {
+			return entry.tag, true
+		}
+	}
+
+	hash := fnv.New64a()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", false
+	}
+
+	tag := `"` + strconv.FormatUint(hash.Sum64(), 16) + `"`
+	c.assetTags.Store(name, assetTag{modTime: info.ModTime(), size: info.Size(), tag: tag})
+
+	return tag, true
+}
+
+func cacheControl(maxAge time.Duration) string {
+	// revalidate on every use without a max age
+	if maxAge <= 0 {
+		return "no-cache"
+	}
+
+	return "public, max-age=" + strconv.Itoa(int(maxAge/time.Second))
+}
+
+const faviconMaxAge = 30 * 24 * time.Hour
+
+func (c *Convergence) viewFavicon(w http.ResponseWriter, r *http.Request) {
+	// browsers ask for icons on every page
+	w.Header().Set("Cache-Control", cacheControl(faviconMaxAge))
+
+	if c.FaviconFile != "" {
+		http.ServeFile(w, r, c.FaviconFile)
+		return
+	}
+
+	// otherwise use the icon of the assets if available
+	file, err := c.assets.Open("/favicon.ico")
+	if err != nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	http.ServeContent(w, r, "favicon.ico", info.ModTime(), file)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pressly/chi"
+)
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Link    atomLink    `xml:"link"`
+	Updated string      `xml:"updated"`
+	Author  *atomAuthor `xml:"author,omitempty"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"feed"`
+	Xmlns   string      `xml:"xmlns,attr"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Links   []atomLink  `xml:"link"`
+	Updated string      `xml:"updated"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+func (c *Convergence) viewFeed(w http.ResponseWriter, r *http.Request) {
+	key := c.spaceKey(chi.URLParam(r, "key"))
+
+	if !c.spaceAllowed(key) {
+		c.showError(w, r, ErrNotFound)
+		return
+	}
+
+	space, err := c.confluence.GetSpace(r.Context(), key)
+	if err != nil {
+		c.showError(w, r, err)
+		return
+	}
+
+	pages, err := c.confluence.GetRecentPages(r.Context(), key, c.FeedSize)
+	if err != nil {
+		c.showError(w, r, err)
+		return
+	}
+
+	base := requestBase(r)
+
+	feed := atomFeed{
+		Xmlns: "http://www.w3.org/2005/Atom",
+		Title: space.Name,
+		ID:    base + "/" + key,
+		Links: []atomLink{
+			{Href: base + "/" + key},
+			{Href: base + r.URL.Path, Rel: "self"},
+		},
+	}
+
+	// the feed is as new as its newest entry
+	var updated time.Time
+
+	for _, page := range pages {
+		link := base + "/" + key + "/" + page.ID + "/" + url.QueryEscape(page.Title)
+
+		entry := atomEntry{
+			Title:   page.Title,
+			ID:      link,
+			Link:    atomLink{Href: link},
+			Updated: page.UpdatedAt.UTC().Format(time.RFC3339),
+		}
+
+		if page.UpdatedBy != "" {
+			entry.Author = &atomAuthor{Name: page.UpdatedBy}
+		}
+
+		if page.UpdatedAt.After(updated) {
+			updated = page.UpdatedAt
+		}
+
+		feed.Entries = append(feed.Entries, entry)
+	}
+
+	// empty spaces have no entry to date the feed by
+	if updated.IsZero() {
+		updated = time.Now()
+	}
+
+	feed.Updated = updated.UTC().Format(time.RFC3339)
+
+	data, err := xml.Marshal(feed)
+	if err != nil {
+		c.showError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	w.Write(data)
+}
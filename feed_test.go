@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pressly/chi"
+)
+
+func TestFeedUpdated(t *testing.T) {
+	tests := []struct {
+		results string
+		updated string
+	}{
+		{`[{"id":"1","type":"page","title":"Home","space":{"key":"ENG"},"version":{"number":2,"when":"2020-01-02T03:04:05.000Z"}}]`, "2020-01-02T03:04:05Z"},
+		// empty spaces are dated by the time of the request
+		{`[]`, ""},
+	}
+
+	for _, test := range tests {
+		c := NewConvergence(newTestConfluence(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+
+			switch r.URL.Path {
+			case "/wiki/rest/api/space/ENG":
+				w.Write([]byte(`{"key":"ENG","name":"Engineering"}`))
+			default:
+				w.Write([]byte(`{"results":` + test.results + `}`))
+			}
+		}), "", "")
+
+		router := chi.NewRouter()
+		router.Get("/feed/:key", c.viewFeed)
+
+		start := time.Now().Add(-time.Second)
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("GET", "/feed/ENG", nil))
+
+		var feed atomFeed
+		if err := xml.Unmarshal(rec.Body.Bytes(), &feed); err != nil {
+			t.Fatalf("GET /feed/ENG = %d %q: %s", rec.Code, rec.Body.String(), err)
+		}
+
+		if test.updated != "" {
+			if feed.Updated != test.updated {
+				t.Errorf("updated = %q; want %q", feed.Updated, test.updated)
+			}
+			continue
+		}
+
+		updated, err := time.Parse(time.RFC3339, feed.Updated)
+		if err != nil || updated.Before(start) {
+			t.Errorf("updated = %q; want the current time", feed.Updated)
+		}
+	}
+}
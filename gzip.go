@@ -0,0 +1,95 @@
+package main
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strings"
+)
+
+var compressibleTypes = []string{
+	"text/",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"image/svg+xml",
+}
+
+type gzipWriter struct {
+	http.ResponseWriter
+
+	level   int
+	writer  *gzip.Writer
+	written bool
+}
+
+func (w *gzipWriter) WriteHeader(status int) {
+	if w.written {
+		return
+	}
+
+	w.written = true
+
+	// compress only bodies of compressible types
+	if status != http.StatusNoContent && status != http.StatusNotModified &&
+		w.Header().Get("Content-Encoding") == "" && compressible(w.Header().Get("Content-Type")) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Del("Content-Length")
+
+		w.writer, _ = gzip.NewWriterLevel(w.ResponseWriter, w.level)
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *gzipWriter) Write(data []byte) (int, error) {
+	// detect content type like net/http would
+	if !w.written {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(data))
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if w.writer != nil {
+		return w.writer.Write(data)
+	}
+
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *gzipWriter) Close() error {
+	if w.writer != nil {
+		return w.writer.Close()
+	}
+
+	return nil
+}
+
+func compressible(contentType string) bool {
+	for _, prefix := range compressibleTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *Convergence) gzipMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// responses vary on accepted encodings
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		// skip if disabled or not supported by the client
+		if c.GzipLevel == gzip.NoCompression || !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		writer := &gzipWriter{ResponseWriter: w, level: c.GzipLevel}
+		defer writer.Close()
+
+		next.ServeHTTP(writer, r)
+	})
+}
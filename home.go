@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/russross/blackfriday"
+)
+
+func (c *Convergence) viewHomeFile(w http.ResponseWriter, r *http.Request) {
+	// read on every request to pick up edits
+	data, err := ioutil.ReadFile(c.HomeFile)
+	if err != nil {
+		c.showError(w, r, err)
+		return
+	}
+
+	// markdown files are converted, everything else is served as html
+	switch strings.ToLower(filepath.Ext(c.HomeFile)) {
+	case ".md", ".markdown":
+		data = blackfriday.MarkdownCommon(data)
+	}
+
+	c.renderIndex(w, r, "Home", c.processBody(string(data)))
+}
+
+func (c *Convergence) viewSpaceList(w http.ResponseWriter, r *http.Request) {
+	// without a home page the spaces are the content
+	spaces, err := c.spaceEntries(r)
+	if err != nil {
+		c.showError(w, r, err)
+		return
+	}
+
+	c.render.HTML(w, http.StatusOK, "index", map[string]interface{}{
+		"Title":    "Spaces",
+		"TopPages": c.TopPages(c.TopPagesSize),
+		"Spaces":   spaces,
+	})
+}
+
+func (c *Convergence) renderIndex(w http.ResponseWriter, r *http.Request, title string, body template.HTML) {
+	var spaces []spaceEntry
+
+	// the home page still works without the list
+	if c.ListSpaces {
+		var err error
+		spaces, err = c.spaceEntries(r)
+		if err != nil {
+			fmt.Printf("Spaces Error: %s\n", err.Error())
+		}
+	}
+
+	c.render.HTML(w, http.StatusOK, "index", map[string]interface{}{
+		"Title":    title,
+		"Body":     body,
+		"TopPages": c.TopPages(c.TopPagesSize),
+		"Spaces":   spaces,
+	})
+}
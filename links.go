@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+type LinkRule struct {
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+var DefaultLinkRules = []LinkRule{
+	// the editor has no local route so edit links show the page
+	{regexp.MustCompile(`^/wiki/spaces/([^/?#]+)/pages/edit-v2/(\d+)(?:[?#].*)?$`), "/$1/$2/page"},
+
+	// cloud page links with and without title
+	{regexp.MustCompile(`^/wiki/spaces/([^/?#]+)/pages/(\d+)/([^?#]+)(.*)$`), "/$1/$2/$3$4"},
+	{regexp.MustCompile(`^/wiki/spaces/([^/?#]+)/pages/(\d+)/?(.*)$`), "/$1/$2/page$3"},
+
+	// cloud blog post links
+	{regexp.MustCompile(`^/wiki/spaces/([^/?#]+)/blog/(?:\d+/\d+/\d+/)?(\d+)/([^?#]+)(.*)$`), "/blog/$1/$2/$3$4"},
+
+	// cloud space links
+	{regexp.MustCompile(`^/wiki/spaces/([^/?#]+)(?:/overview)?/?([?#].*)?$`), "/$1$2"},
+
+	// display links by title
+	{regexp.MustCompile(`^/wiki/display/([^/?#]+)/([^?#]+)(.*)$`), "/display/$1/$2$3"},
+	{regexp.MustCompile(`^/wiki/display/([^/?#]+)/?([?#].*)?$`), "/$1$2"},
+
+	// attachments
+	{regexp.MustCompile(`^/wiki/download/attachments/(.*)$`), "/download/attachments/$1"},
+
+	// tiny links are resolved when followed
+	{regexp.MustCompile(`^/wiki/x/([A-Za-z0-9_-]+)(.*)$`), "/x/$1$2"},
+}
+
+var linkAttributes = map[string]string{
+	"a":   "href",
+	"img": "src",
+}
+
+func rewriteLinks(body string, rules []LinkRule) string {
+	var buf bytes.Buffer
+	z := html.NewTokenizer(strings.NewReader(body))
+
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if z.Err() != io.EOF {
+				// keep original body on parse errors
+				return body
+			}
+
+			return buf.String()
+		}
+
+		// only start tags may carry links
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			buf.Write(z.Raw())
+			continue
+		}
+
+		raw := string(z.Raw())
+		token := z.Token()
+
+		attr, ok := linkAttributes[token.Data]
+		if !ok {
+			buf.WriteString(raw)
+			continue
+		}
+
+		changed := false
+		for i, a := range token.Attr {
+			if a.Key == attr {
+				if value, ok := rewriteLink(a.Val, rules); ok {
+					token.Attr[i].Val = value
+					changed = true
+				}
+			}
+		}
+
+		if changed {
+			buf.WriteString(token.String())
+		} else {
+			buf.WriteString(raw)
+		}
+	}
+}
+
+func rewriteLink(link string, rules []LinkRule) (string, bool) {
+	for _, rule := range rules {
+		if rule.Pattern.MatchString(link) {
+			return rule.Pattern.ReplaceAllString(link, rule.Replacement), true
+		}
+	}
+
+	return link, false
+}
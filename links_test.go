@@ -0,0 +1,72 @@
+package main
+
+import "testing"
+
+func TestRewriteLink(t *testing.T) {
+	tests := []struct {
+		link    string
+		want    string
+		changed bool
+	}{
+		{"/wiki/spaces/ENG/pages/123/Some+Page", "/ENG/123/Some+Page", true},
+		{"/wiki/spaces/ENG/pages/123/Some+Page#Intro", "/ENG/123/Some+Page#Intro", true},
+		{"/wiki/spaces/ENG/pages/123", "/ENG/123/page", true},
+		{"/wiki/spaces/ENG/blog/2020/01/02/456/News", "/blog/ENG/456/News", true},
+		{"/wiki/spaces/ENG/blog/456/News", "/blog/ENG/456/News", true},
+		{"/wiki/spaces/ENG", "/ENG", true},
+		{"/wiki/spaces/ENG/", "/ENG", true},
+		{"/wiki/spaces/ENG/overview", "/ENG", true},
+		{"/wiki/spaces/ENG/overview?mode=global", "/ENG?mode=global", true},
+		{"/wiki/spaces/ENG/pages/edit-v2/123", "/ENG/123/page", true},
+		{"/wiki/spaces/ENG/pages/edit-v2/123?draftShareId=abc", "/ENG/123/page", true},
+		{"/wiki/spaces/ENG/pages/create", "/wiki/spaces/ENG/pages/create", false},
+		{"/wiki/spaces/ENG/pages/resumedraft.action?draftId=1", "/wiki/spaces/ENG/pages/resumedraft.action?draftId=1", false},
+		{"/wiki/pages/editpage.action?pageId=123", "/wiki/pages/editpage.action?pageId=123", false},
+		{"/wiki/spaces/ENG/Some+Page", "/wiki/spaces/ENG/Some+Page", false},
+		{"/wiki/display/ENG/Title", "/display/ENG/Title", true},
+		{"/wiki/display/ENG/Title#Intro", "/display/ENG/Title#Intro", true},
+		{"/wiki/display/ENG", "/ENG", true},
+		{"/wiki/display/ENG/", "/ENG", true},
+		{"/wiki/download/attachments/123/a%20b.png?version=2", "/download/attachments/123/a%20b.png?version=2", true},
+		{"/wiki/x/AbCd", "/x/AbCd", true},
+		{"https://example.com/wiki/spaces/ENG", "https://example.com/wiki/spaces/ENG", false},
+		{"/other", "/other", false},
+	}
+
+	for _, test := range tests {
+		got, changed := rewriteLink(test.link, DefaultLinkRules)
+		if got != test.want || changed != test.changed {
+			t.Errorf("rewriteLink(%q) = %q, %v; want %q, %v", test.link, got, changed, test.want, test.changed)
+		}
+	}
+}
+
+func TestRewriteLinks(t *testing.T) {
+	tests := []struct {
+		body string
+		want string
+	}{
+		{
+			`<p><a href="/wiki/spaces/ENG/pages/1/Home">home</a></p>`,
+			`<p><a href="/ENG/1/Home">home</a></p>`,
+		},
+		{
+			`<img src="/wiki/download/attachments/1/a.png"/>`,
+			`<img src="/download/attachments/1/a.png"/>`,
+		},
+		{
+			`<a class="x" href="/wiki/display/ENG">space</a>`,
+			`<a class="x" href="/ENG">space</a>`,
+		},
+		{
+			`<a href="https://example.com">external</a><span data-href="/wiki/spaces/ENG">kept</span>`,
+			`<a href="https://example.com">external</a><span data-href="/wiki/spaces/ENG">kept</span>`,
+		},
+	}
+
+	for _, test := range tests {
+		if got := rewriteLinks(test.body, DefaultLinkRules); got != test.want {
+			t.Errorf("rewriteLinks(%q) = %q; want %q", test.body, got, test.want)
+		}
+	}
+}
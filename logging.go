@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/pressly/chi"
+)
+
+type accessEntry struct {
+	Time    time.Time `json:"time"`
+	Method  string    `json:"method"`
+	Path    string    `json:"path"`
+	Route   string    `json:"route,omitempty"`
+	Status  int       `json:"status"`
+	Latency float64   `json:"latency"`
+	Key     string    `json:"key,omitempty"`
+	ID      string    `json:"id,omitempty"`
+	Cached  bool      `json:"cached"`
+	Stale   bool      `json:"stale,omitempty"`
+}
+
+type accessEntryKey struct{}
+
+func (c *Convergence) accessLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// skip if disabled
+		if c.AccessLog == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		entry := &accessEntry{
+			Time:   time.Now(),
+			Method: r.Method,
+			Path:   r.URL.Path,
+		}
+
+		// collect route details and cache usage
+		ctx, trace := WithCacheTrace(context.WithValue(r.Context(), accessEntryKey{}, entry))
+		writer := &statusWriter{ResponseWriter: w}
+
+		next.ServeHTTP(writer, r.WithContext(ctx))
+
+		// handlers that never write respond with 200
+		if writer.status == 0 {
+			writer.status = http.StatusOK
+		}
+
+		entry.Status = writer.status
+		entry.Latency = time.Since(entry.Time).Seconds() * 1000
+		entry.Cached = trace.Cached()
+		entry.Stale = trace.ServedStale()
+
+		c.writeAccessLog(entry)
+	})
+}
+
+func (c *Convergence) writeAccessLog(entry *accessEntry) {
+	if c.AccessLogJSON {
+		data, err := json.Marshal(entry)
+		if err == nil {
+			c.AccessLog.Printf("%s", data)
+		}
+
+		return
+	}
+
+	c.AccessLog.Printf("%s %s %d %.2fms route=%s key=%s id=%s cached=%t stale=%t",
+		entry.Method, entry.Path, entry.Status, entry.Latency,
+		entry.Route, entry.Key, entry.ID, entry.Cached, entry.Stale)
+}
+
+func recordRoute(r *http.Request, route string) {
+	// url params are only known once routed
+	if entry, ok := r.Context().Value(accessEntryKey{}).(*accessEntry); ok {
+		entry.Route = route
+		entry.Key = chi.URLParam(r, "key")
+		entry.ID = chi.URLParam(r, "id")
+
+		// search and label views take the space as a query
+		if entry.Key == "" {
+			entry.Key = r.URL.Query().Get("space")
+		}
+	}
+}
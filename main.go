@@ -1,19 +1,377 @@
 package main
 
-import "os"
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
 
 func main() {
-	confluence := NewConfluence(
-		os.Getenv("BASE_URL"),
-		os.Getenv("USERNAME"),
-		os.Getenv("PASSWORD"),
-	)
+	// flags override environment variables
+	config := ConfluenceConfigFromEnv()
+	flag.StringVar(&config.BaseURL, "base-url", config.BaseURL, "Confluence base URL (CONFLUENCE_BASE_URL)")
+	flag.StringVar(&config.Flavor, "flavor", config.Flavor, "Confluence flavor, cloud or server (CONFLUENCE_FLAVOR)")
+	flag.StringVar(&config.Username, "username", config.Username, "Confluence username (CONFLUENCE_USERNAME)")
+	flag.StringVar(&config.Password, "password", config.Password, "Confluence password (CONFLUENCE_PASSWORD)")
+	flag.StringVar(&config.Token, "token", config.Token, "Confluence personal access token (CONFLUENCE_TOKEN)")
+
+	addr := flag.String("addr", os.Getenv("ADDR"), "listen address (ADDR)")
+	cacheTTL := flag.String("cache-ttl", os.Getenv("CACHE_TTL"), "content cache duration (CACHE_TTL)")
+	homeSpaceKey := flag.String("home-space", os.Getenv("HOME_SPACE_KEY"), "space of the home page (HOME_SPACE_KEY)")
+	homePageTitle := flag.String("home-page", os.Getenv("HOME_PAGE_TITLE"), "title or id of the home page (HOME_PAGE_TITLE)")
+	templatesDir := flag.String("templates", os.Getenv("TEMPLATES_DIR"), "directory with template overrides (TEMPLATES_DIR)")
+	assetsDir := flag.String("assets", os.Getenv("ASSETS_DIR"), "directory with asset overrides (ASSETS_DIR)")
+	debug := flag.Bool("debug", os.Getenv("DEBUG") != "", "log Confluence requests (DEBUG)")
+
+	flag.Parse()
 
-	convergence := NewConvergence(
-		confluence,
-		os.Getenv("HOME_SPACE_KEY"),
-		os.Getenv("HOME_PAGE_TITLE"),
+	confluence, err := NewConfluenceFromConfig(config)
+	if err != nil {
+		usage(err)
+	}
+
+	// configure connection to confluence
+	confluence.Transport, err = newTransport(
+		os.Getenv("CONFLUENCE_CA_FILE"),
+		os.Getenv("CONFLUENCE_PROXY"),
+		os.Getenv("CONFLUENCE_INSECURE") != "",
 	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// configure listed space type
+	if spaceType, ok := os.LookupEnv("SPACE_TYPE"); ok {
+		confluence.SpaceType = spaceType
+	}
+
+	// configure cache duration
+	if *cacheTTL != "" {
+		ttl, err := time.ParseDuration(*cacheTTL)
+		if err != nil {
+			usage(errors.New("invalid cache ttl: " + *cacheTTL))
+		}
+
+		confluence.CacheTTL = ttl
+	}
+
+	// configure how often expired entries are removed from memory
+	parseDurationEnv("CACHE_CLEANUP", &confluence.CacheCleanup)
+
+	// use separate accounts for some spaces
+	credentials, err := ParseSpaceCredentials(os.Getenv("CONFLUENCE_SPACE_CREDENTIALS"))
+	if err != nil {
+		usage(err)
+	}
+
+	confluence.SpaceCredentials = credentials
+
+	// configure largest cached attachment
+	parseSizeEnv("MAX_ATTACHMENT_SIZE", &confluence.MaxAttachmentSize)
+
+	parseIntEnv("TOC_DEPTH", &confluence.TOCDepth, 0)
+
+	// trusted deployments may render bodies unchanged
+	if os.Getenv("SANITIZE") == "off" {
+		confluence.Sanitizer = nil
+	}
+
+	// bound memory used by cached attachments
+	parseSizeEnv("ATTACHMENT_CACHE_SIZE", &confluence.AttachmentCacheSize)
+
+	// share cache across instances
+	if redisURL := os.Getenv("REDIS_URL"); redisURL != "" {
+		confluence.Cache = NewRedisCache(redisURL)
+	}
+
+	// separate instances sharing a cache
+	prefix, ok := os.LookupEnv("CACHE_PREFIX")
+	if !ok {
+		prefix = CachePrefix(config.BaseURL)
+	}
+	if prefix != "" {
+		confluence.Cache = NewPrefixedCache(confluence.cache(), prefix)
+	}
+
+	// cache content types for different durations
+	parseDurationEnv("SPACES_TTL", &confluence.SpacesTTL)
+	parseDurationEnv("PAGES_TTL", &confluence.PagesTTL)
+	parseDurationEnv("ATTACHMENTS_TTL", &confluence.AttachmentsTTL)
+	parseDurationEnv("STALE_TTL", &confluence.StaleTTL)
+	parseDurationEnv("STALE_IF_ERROR_TTL", &confluence.StaleIfErrorTTL)
+
+	// configure how long missing content is remembered
+	parseDurationEnv("NEGATIVE_CACHE_TTL", &confluence.NegativeCacheTTL)
+
+	// configure cache warming and upstream concurrency
+	parseIntEnv("WARM_DEPTH", &confluence.WarmDepth, 0)
+	parseIntEnv("WARM_CONCURRENCY", &confluence.WarmConcurrency, 1)
+	parseIntEnv("MAX_CONCURRENCY", &confluence.MaxConcurrency, 0)
+
+	// enable client logging
+	if *debug {
+		confluence.Logger = log.New(os.Stdout, "confluence: ", log.LstdFlags)
+	}
+
+	// configure home page as KEY/Title
+	if home := os.Getenv("HOME_PAGE"); home != "" && *homeSpaceKey == "" && *homePageTitle == "" {
+		parts := strings.SplitN(home, "/", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			usage(errors.New("invalid home page: " + home))
+		}
+
+		*homeSpaceKey, *homePageTitle = parts[0], parts[1]
+	}
+
+	convergence := NewConvergence(confluence, *homeSpaceKey, *homePageTitle)
+
+	convergence.Addr = *addr
+	convergence.PublicURL = os.Getenv("PUBLIC_URL")
+	convergence.TemplatesDir = *templatesDir
+	convergence.AssetsDir = *assetsDir
+	convergence.HomeFile = os.Getenv("HOME_FILE")
+	convergence.TLSCertFile = os.Getenv("TLS_CERT_FILE")
+	convergence.TLSKeyFile = os.Getenv("TLS_KEY_FILE")
+
+	if addr := os.Getenv("TLS_ADDR"); addr != "" {
+		convergence.TLSAddr = addr
+	}
+
+	convergence.AllowedSpaces = splitList(os.Getenv("ALLOWED_SPACES"))
+	convergence.DeniedSpaces = splitList(os.Getenv("DENIED_SPACES"))
+	convergence.CORSOrigins = splitList(os.Getenv("CORS_ORIGINS"))
+
+	if expand := splitList(os.Getenv("API_EXPAND")); len(expand) > 0 {
+		convergence.APIExpand = expand
+	}
+
+	if methods := splitList(os.Getenv("CORS_METHODS")); len(methods) > 0 {
+		convergence.CORSMethods = methods
+	}
+
+	if headers := splitList(os.Getenv("CORS_HEADERS")); len(headers) > 0 {
+		convergence.CORSHeaders = headers
+	}
+
+	// configure sitemap rebuild interval
+	parseDurationEnv("SITEMAP_TTL", &convergence.SitemapTTL)
+
+	// configure feed length
+	parseIntEnv("FEED_SIZE", &convergence.FeedSize, 1)
+
+	// configure rate limiting per client
+	parseFloatEnv("RATE_LIMIT", &convergence.RateLimit)
+	parseIntEnv("RATE_BURST", &convergence.RateBurst, 1)
+
+	convergence.RateLimitBySpace = os.Getenv("RATE_LIMIT_BY_SPACE") != ""
+	convergence.TrustProxy = os.Getenv("TRUST_PROXY") != ""
+	convergence.UppercaseKeys = os.Getenv("UPPERCASE_KEYS") != ""
+	convergence.DefaultSpace = os.Getenv("DEFAULT_SPACE")
+	convergence.ListSpaces = os.Getenv("LIST_SPACES") != ""
+	convergence.Minify = os.Getenv("MINIFY") != ""
+	convergence.AdminToken = os.Getenv("ADMIN_TOKEN")
+	convergence.WebhookSecret = os.Getenv("WEBHOOK_SECRET")
+
+	parseDurationEnv("ASSET_MAX_AGE", &convergence.AssetMaxAge)
+	parseDurationEnv("PAGE_MAX_AGE", &convergence.PageMaxAge)
+	parseIntEnv("TOP_PAGES_SIZE", &convergence.TopPagesSize, 0)
+	parseDurationEnv("TOP_PAGES_WINDOW", &convergence.TopPagesWindow)
+
+	// allow branded error pages
+	if name := os.Getenv("NOT_FOUND_TEMPLATE"); name != "" {
+		convergence.NotFoundTemplate = name
+	}
+
+	if name := os.Getenv("AUTH_TEMPLATE"); name != "" {
+		convergence.AuthTemplate = name
+	}
+
+	if name := os.Getenv("UPSTREAM_TEMPLATE"); name != "" {
+		convergence.UpstreamTemplate = name
+	}
+
+	if name := os.Getenv("ERROR_TEMPLATE"); name != "" {
+		convergence.ErrorTemplate = name
+	}
+
+	if path := os.Getenv("FAVICON_FILE"); path != "" {
+		convergence.FaviconFile = path
+	}
+
+	// serve custom or no crawler rules
+	switch path := os.Getenv("ROBOTS_FILE"); path {
+	case "":
+	case "off":
+		convergence.Robots = ""
+	default:
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		convergence.Robots = string(data)
+	}
+
+	if path := os.Getenv("WKHTMLTOPDF_PATH"); path != "" {
+		convergence.PDFRenderer = NewWkhtmltopdf(path)
+	}
+
+	// enable access logging
+	switch os.Getenv("ACCESS_LOG") {
+	case "":
+	case "json":
+		convergence.AccessLog = log.New(os.Stdout, "", 0)
+		convergence.AccessLogJSON = true
+	default:
+		convergence.AccessLog = log.New(os.Stdout, "access: ", log.LstdFlags)
+	}
+
+	// fill the cache after deploys
+	if spaces := os.Getenv("WARM_SPACES"); spaces != "" {
+		go func() {
+			var keys []string
+			if spaces != "*" {
+				keys = splitList(spaces)
+			}
+
+			count, err := confluence.Warm(context.Background(), keys, convergence.spaceAllowed)
+			if err != nil {
+				fmt.Printf("Warm Error: %s\n", err.Error())
+			}
+
+			fmt.Printf("Warmed %d items\n", count)
+		}()
+	}
+
+	if err := convergence.Run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func usage(err error) {
+	fmt.Fprintf(os.Stderr, "Error: %s\n\n", err.Error())
+	flag.Usage()
+	os.Exit(2)
+}
+
+func parseDurationEnv(name string, value *time.Duration) {
+	// unset variables keep the default
+	str := os.Getenv(name)
+	if str == "" {
+		return
+	}
+
+	duration, err := time.ParseDuration(str)
+	if err != nil || duration < 0 {
+		usage(errors.New("invalid " + name + ": " + str))
+	}
+
+	*value = duration
+}
+
+func parseIntEnv(name string, value *int, min int) {
+	str := os.Getenv(name)
+	if str == "" {
+		return
+	}
+
+	number, err := strconv.Atoi(str)
+	if err != nil || number < min {
+		usage(errors.New("invalid " + name + ": " + str))
+	}
+
+	*value = number
+}
+
+func parseSizeEnv(name string, value *int64) {
+	str := os.Getenv(name)
+	if str == "" {
+		return
+	}
+
+	size, err := strconv.ParseInt(str, 10, 64)
+	if err != nil || size < 0 {
+		usage(errors.New("invalid " + name + ": " + str))
+	}
+
+	*value = size
+}
+
+func parseFloatEnv(name string, value *float64) {
+	str := os.Getenv(name)
+	if str == "" {
+		return
+	}
+
+	number, err := strconv.ParseFloat(str, 64)
+	if err != nil || number < 0 {
+		usage(errors.New("invalid " + name + ": " + str))
+	}
+
+	*value = number
+}
+
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
+
+func newTransport(caFile, proxy string, insecure bool) (*http.Transport, error) {
+	// keep default transport if not configured
+	if caFile == "" && proxy == "" && !insecure {
+		return nil, nil
+	}
+
+	transport := &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: insecure},
+	}
+
+	// trust additional certificate authority
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in " + caFile)
+		}
+
+		transport.TLSClientConfig.RootCAs = pool
+	}
+
+	// use explicit proxy
+	if proxy != "" {
+		proxyURL, err := url.Parse(proxy)
+		if err != nil {
+			return nil, err
+		}
+
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
 
-	convergence.Run()
+	return transport, nil
 }
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	"*", `\*`,
+	"_", `\_`,
+	"`", "\\`",
+	"[", `\[`,
+	"]", `\]`,
+)
+
+var markdownSpace = regexp.MustCompile(`[ \t\r\n]+`)
+var markdownBlank = regexp.MustCompile(`\n[ \t]*(?:\n[ \t]*)+\n`)
+var markdownBrush = regexp.MustCompile(`brush:\s*([\w+#-]+)`)
+var markdownGap = regexp.MustCompile(`\n\s*\n`)
+
+var markdownPanels = map[string]string{
+	"information": "Info",
+	"note":        "Note",
+	"warning":     "Warning",
+	"tip":         "Tip",
+}
+
+func toMarkdown(body string) string {
+	nodes, err := html.ParseFragment(strings.NewReader(body), &html.Node{
+		Type:     html.ElementNode,
+		Data:     "body",
+		DataAtom: atom.Body,
+	})
+	if err != nil {
+		return body
+	}
+
+	var buf strings.Builder
+	for _, node := range nodes {
+		buf.WriteString(markdownNode(node))
+	}
+
+	return strings.TrimSpace(markdownBlank.ReplaceAllString(buf.String(), "\n\n")) + "\n"
+}
+
+func markdownChildren(node *html.Node) string {
+	var buf strings.Builder
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		buf.WriteString(markdownNode(child))
+	}
+
+	return buf.String()
+}
+
+func markdownNode(node *html.Node) string {
+	switch node.Type {
+	case html.TextNode:
+		return markdownEscaper.Replace(markdownSpace.ReplaceAllString(node.Data, " "))
+	case html.ElementNode:
+	default:
+		return ""
+	}
+
+	switch node.DataAtom {
+	case atom.Script, atom.Style:
+		return ""
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		level, _ := strconv.Atoi(node.Data[1:])
+		return markdownBlock(strings.Repeat("#", level) + " " + markdownInline(node))
+	case atom.P:
+		return markdownBlock(markdownChildren(node))
+	case atom.Div:
+		// confluence renders info, note, warning and tip macros as panels
+		if kind := markdownPanel(node); kind != "" {
+			return markdownBlock(markdownQuote("**" + kind + ":** " + strings.TrimSpace(markdownChildren(node))))
+		}
+
+		return markdownBlock(markdownChildren(node))
+	case atom.Br:
+		return "  \n"
+	case atom.Hr:
+		return markdownBlock("---")
+	case atom.Strong, atom.B:
+		return markdownWrap(markdownChildren(node), "**")
+	case atom.Em, atom.I:
+		return markdownWrap(markdownChildren(node), "_")
+	case atom.Del, atom.S:
+		return markdownWrap(markdownChildren(node), "~~")
+	case atom.Code:
+		return markdownWrap(nodeText(node), "`")
+	case atom.A:
+		text := strings.TrimSpace(markdownChildren(node))
+		href := nodeAttr(node, "href")
+		if href == "" {
+			return text
+		}
+
+		return "[" + text + "](" + href + ")"
+	case atom.Img:
+		return "![" + markdownEscaper.Replace(nodeAttr(node, "alt")) + "](" + nodeAttr(node, "src") + ")"
+	case atom.Pre:
+		return markdownBlock(markdownCode(node))
+	case atom.Blockquote:
+		return markdownBlock(markdownQuote(strings.TrimSpace(markdownChildren(node))))
+	case atom.Ul, atom.Ol:
+		return markdownBlock(markdownList(node))
+	case atom.Table:
+		return markdownBlock(markdownTable(node))
+	}
+
+	return markdownChildren(node)
+}
+
+func markdownBlock(text string) string {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return ""
+	}
+
+	return "\n\n" + text + "\n\n"
+}
+
+func markdownInline(node *html.Node) string {
+	return strings.TrimSpace(markdownSpace.ReplaceAllString(markdownChildren(node), " "))
+}
+
+func markdownWrap(text, marker string) string {
+	// keep surrounding space outside of the markers
+	trimmed := strings.TrimSpace(text)
+	if trimmed == "" {
+		return text
+	}
+
+	start := text[:strings.Index(text, trimmed)]
+	end := text[len(start)+len(trimmed):]
+
+	return start + marker + trimmed + marker + end
+}
+
+func markdownPanel(node *html.Node) string {
+	for _, class := range strings.Fields(nodeAttr(node, "class")) {
+		if strings.HasPrefix(class, "confluence-information-macro-") {
+			if kind, ok := markdownPanels[strings.TrimPrefix(class, "confluence-information-macro-")]; ok {
+				return kind
+			}
+		}
+	}
+
+	return ""
+}
+
+func markdownCode(node *html.Node) string {
+	// syntax highlighter blocks carry their language as a brush
+	lang := ""
+	if match := markdownBrush.FindStringSubmatch(nodeAttr(node, "data-syntaxhighlighter-params")); match != nil {
+		lang = match[1]
+	}
+
+	code := strings.Trim(nodeText(node), "\n")
+
+	fence := "```"
+	for strings.Contains(code, fence) {
+		fence += "`"
+	}
+
+	return fence + lang + "\n" + code + "\n" + fence
+}
+
+func markdownQuote(text string) string {
+	lines := strings.Split(markdownBlank.ReplaceAllString(text, "\n\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight("> "+line, " ")
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func markdownList(node *html.Node) string {
+	var items []string
+	number := 1
+
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if child.DataAtom != atom.Li {
+			continue
+		}
+
+		prefix := "- "
+		if node.DataAtom == atom.Ol {
+			prefix = strconv.Itoa(number) + ". "
+			number++
+		}
+
+		// keep items tight and indent continuation lines
+		text := strings.TrimSpace(markdownChildren(child))
+		lines := strings.Split(markdownGap.ReplaceAllString(text, "\n"), "\n")
+		for i := 1; i < len(lines); i++ {
+			lines[i] = strings.Repeat(" ", len(prefix)) + lines[i]
+		}
+
+		items = append(items, prefix+strings.Join(lines, "\n"))
+	}
+
+	return strings.Join(items, "\n")
+}
+
+func markdownTable(node *html.Node) string {
+	var rows [][]string
+	var walk func(*html.Node)
+
+	walk = func(n *html.Node) {
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if child.DataAtom != atom.Tr {
+				walk(child)
+				continue
+			}
+
+			var row []string
+			for cell := child.FirstChild; cell != nil; cell = cell.NextSibling {
+				if cell.DataAtom == atom.Td || cell.DataAtom == atom.Th {
+					row = append(row, strings.Replace(markdownInline(cell), "|", `\|`, -1))
+				}
+			}
+
+			rows = append(rows, row)
+		}
+	}
+
+	walk(node)
+
+	if len(rows) == 0 {
+		return ""
+	}
+
+	// the first row always acts as header
+	width := 0
+	for _, row := range rows {
+		if len(row) > width {
+			width = len(row)
+		}
+	}
+
+	var lines []string
+	for i, row := range rows {
+		for len(row) < width {
+			row = append(row, "")
+		}
+
+		lines = append(lines, "| "+strings.Join(row, " | ")+" |")
+		if i == 0 {
+			lines = append(lines, "|"+strings.Repeat(" --- |", width))
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func (c *Convergence) markdown(body string) string {
+	return toMarkdown(rewriteLinks(body, c.LinkRules))
+}
+
+func (c *Convergence) viewMarkdown(w http.ResponseWriter, r *http.Request, page *Page) {
+	if c.notModified(w, r, etag("markdown", page.ID, page.Title, page.Body, strconv.Itoa(page.Version))) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("# " + page.Title + "\n\n" + c.markdown(page.Body)))
+}
+
+func acceptsMarkdown(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/markdown")
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "convergence_requests_total",
+	Help: "Number of handled requests by route and status.",
+}, []string{"route", "status"})
+
+var requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "convergence_request_duration_seconds",
+	Help:    "Duration of handled requests by route.",
+	Buckets: prometheus.DefBuckets,
+}, []string{"route"})
+
+var upstreamDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "convergence_upstream_duration_seconds",
+	Help:    "Duration of Confluence requests by status.",
+	Buckets: prometheus.DefBuckets,
+}, []string{"status"})
+
+var cacheHits = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "convergence_cache_hits_total",
+	Help: "Number of cache hits by key type.",
+}, []string{"type"})
+
+var cacheMisses = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "convergence_cache_misses_total",
+	Help: "Number of cache misses by key type.",
+}, []string{"type"})
+
+var attachmentCacheBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "convergence_attachment_cache_bytes",
+	Help: "Size of cached attachments in bytes.",
+})
+
+var upstreamInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "convergence_upstream_in_flight",
+	Help: "Number of Confluence requests in flight.",
+})
+
+func init() {
+	prometheus.MustRegister(requestsTotal, requestDuration, upstreamDuration, cacheHits, cacheMisses, attachmentCacheBytes, upstreamInFlight)
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	return w.ResponseWriter.Write(data)
+}
+
+func instrument(route string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		writer := &statusWriter{ResponseWriter: w}
+
+		recordRoute(r, route)
+		traceRoute(r, route)
+		handler(writer, r)
+
+		// handlers that never write respond with 200
+		if writer.status == 0 {
+			writer.status = http.StatusOK
+		}
+
+		requestsTotal.WithLabelValues(route, strconv.Itoa(writer.status)).Inc()
+		requestDuration.WithLabelValues(route).Observe(time.Since(start).Seconds())
+	}
+}
+
+func cacheType(key string) string {
+	// keys are prefixed with their type
+	if i := strings.Index(key, "-"); i > 0 {
+		return key[:i]
+	}
+
+	return key
+}
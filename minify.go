@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var minifySpace = regexp.MustCompile(`[ \t\r\n\f]+`)
+
+type minifyWriter struct {
+	http.ResponseWriter
+
+	buffer  *bytes.Buffer
+	written bool
+}
+
+func (w *minifyWriter) WriteHeader(status int) {
+	if w.written {
+		return
+	}
+
+	w.written = true
+
+	// collect html bodies to minify them as a whole
+	if status != http.StatusNoContent && status != http.StatusNotModified &&
+		w.Header().Get("Content-Encoding") == "" && strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+		w.Header().Del("Content-Length")
+
+		w.buffer = &bytes.Buffer{}
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *minifyWriter) Write(data []byte) (int, error) {
+	// detect content type like net/http would
+	if !w.written {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(data))
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if w.buffer != nil {
+		return w.buffer.Write(data)
+	}
+
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *minifyWriter) Close() error {
+	if w.buffer != nil {
+		_, err := w.ResponseWriter.Write(minifyHTML(w.buffer.Bytes()))
+		return err
+	}
+
+	return nil
+}
+
+func minifyHTML(data []byte) []byte {
+	var buf bytes.Buffer
+	buf.Grow(len(data))
+
+	tokenizer := html.NewTokenizer(bytes.NewReader(data))
+
+	// whitespace is significant within these elements
+	preserved := 0
+
+	for {
+		kind := tokenizer.Next()
+		if kind == html.ErrorToken {
+			return buf.Bytes()
+		}
+
+		// tag names are lowered in place when inspected
+		raw := append([]byte(nil), tokenizer.Raw()...)
+
+		switch kind {
+		case html.TextToken:
+			if preserved == 0 {
+				raw = minifySpace.ReplaceAll(raw, []byte(" "))
+			}
+		case html.CommentToken:
+			// keep conditional comments
+			if !bytes.Contains(raw, []byte("[if")) {
+				continue
+			}
+		case html.StartTagToken:
+			if preservesSpace(tokenizer) {
+				preserved++
+			}
+		case html.EndTagToken:
+			if preservesSpace(tokenizer) && preserved > 0 {
+				preserved--
+			}
+		}
+
+		buf.Write(raw)
+	}
+}
+
+func preservesSpace(tokenizer *html.Tokenizer) bool {
+	name, _ := tokenizer.TagName()
+
+	switch atom.Lookup(name) {
+	case atom.Pre, atom.Code, atom.Textarea, atom.Script, atom.Style:
+		return true
+	}
+
+	return false
+}
+
+func (c *Convergence) minifyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// skip if disabled
+		if !c.Minify {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		writer := &minifyWriter{ResponseWriter: w}
+		defer writer.Close()
+
+		next.ServeHTTP(writer, r)
+	})
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/unrolled/render"
+)
+
+type PDFRenderer interface {
+	RenderPDF(ctx context.Context, page []byte) ([]byte, error)
+}
+
+type Wkhtmltopdf struct {
+	Path string
+	Args []string
+}
+
+func NewWkhtmltopdf(path string) *Wkhtmltopdf {
+	return &Wkhtmltopdf{
+		Path: path,
+		Args: []string{"--quiet", "--print-media-type", "--encoding", "utf-8"},
+	}
+}
+
+func (p *Wkhtmltopdf) RenderPDF(ctx context.Context, page []byte) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+
+	// read the page from stdin and write the document to stdout
+	cmd := exec.CommandContext(ctx, p.Path, append(p.Args, "-", "-")...)
+	cmd.Stdin = bytes.NewReader(page)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		// include the diagnostics if there are any
+		if output := strings.TrimSpace(stderr.String()); output != "" {
+			return nil, fmt.Errorf("wkhtmltopdf: %s: %s", err.Error(), output)
+		}
+
+		return nil, fmt.Errorf("wkhtmltopdf: %s", err.Error())
+	}
+
+	return stdout.Bytes(), nil
+}
+
+func (c *Convergence) viewPDF(w http.ResponseWriter, r *http.Request, space *Space, page *Page) {
+	// check if enabled
+	if c.PDFRenderer == nil {
+		c.showError(w, r, ErrNotFound)
+		return
+	}
+
+	if c.notModified(w, r, etag("pdf", page.ID, strconv.Itoa(page.Version))) {
+		return
+	}
+
+	// documents only change with the page version
+	key := "pdf-" + page.ID + "-" + strconv.Itoa(page.Version)
+
+	data, ok := c.confluence.cache().Get(key)
+	if !ok {
+		// images resolve against this server but never against the requested host
+		binding := c.printBinding(space, page)
+		binding["Base"] = c.localBase() + "/"
+
+		var buf bytes.Buffer
+		err := c.render.HTML(&buf, http.StatusOK, "print", binding, render.HTMLOptions{})
+		if err != nil {
+			c.showError(w, r, err)
+			return
+		}
+
+		document, err := c.PDFRenderer.RenderPDF(r.Context(), buf.Bytes())
+		if err != nil {
+			c.showError(w, r, err)
+			return
+		}
+
+		c.confluence.cacheContent(key, document)
+		data = document
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{
+		"filename": page.Title + ".pdf",
+	}))
+	w.WriteHeader(http.StatusOK)
+	w.Write(data.([]byte))
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type echoRenderer struct{}
+
+func (echoRenderer) RenderPDF(ctx context.Context, page []byte) ([]byte, error) {
+	// the document is the page it was rendered from
+	return page, nil
+}
+
+func TestPDFBase(t *testing.T) {
+	tests := []struct {
+		addr      string
+		publicURL string
+		base      string
+	}{
+		{":8080", "", `<base href="http://localhost:8080/">`},
+		{"127.0.0.1:9000", "", `<base href="http://127.0.0.1:9000/">`},
+		{":8080", "https://wiki.example.com/", `<base href="https://wiki.example.com/">`},
+	}
+
+	for _, test := range tests {
+		c := NewConvergence(newTestConfluence(t, spacesHandler), "", "")
+		c.Addr = test.addr
+		c.PublicURL = test.publicURL
+		c.PDFRenderer = echoRenderer{}
+
+		space := &Space{Key: "ENG", Name: "Engineering"}
+		page := &Page{ID: "1", Title: "Home", SpaceKey: "ENG", Version: 1}
+
+		var documents [][]byte
+		for _, host := range []string{"evil.example.com", "wiki.example.com"} {
+			r := httptest.NewRequest("GET", "/ENG/1/Home.pdf", nil)
+			r.Host = host
+			r.Header.Set("X-Forwarded-Proto", "https")
+
+			rec := httptest.NewRecorder()
+			c.viewPDF(rec, r, space, page)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("GET with host %s = %d; want %d", host, rec.Code, http.StatusOK)
+			}
+
+			documents = append(documents, rec.Body.Bytes())
+		}
+
+		if bytes.Contains(documents[0], []byte("evil.example.com")) {
+			t.Errorf("addr %q: document uses the requested host", test.addr)
+		}
+		if !bytes.Contains(documents[0], []byte(test.base)) {
+			t.Errorf("addr %q: document lacks %s", test.addr, test.base)
+		}
+		if !bytes.Equal(documents[0], documents[1]) {
+			t.Errorf("addr %q: cached document changed between hosts", test.addr)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+type pageCount struct {
+	page  *Page
+	views int
+}
+
+type pageViews struct {
+	mutex  sync.Mutex
+	counts map[string]*pageCount
+	start  time.Time
+}
+
+func (v *pageViews) record(key string, page *Page, window time.Duration) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	v.expire(window)
+
+	if v.counts == nil {
+		v.counts = make(map[string]*pageCount)
+	}
+
+	id := key + "-" + page.ID
+
+	// keep a small copy without the body
+	count, ok := v.counts[id]
+	if !ok {
+		count = &pageCount{}
+		v.counts[id] = count
+	}
+
+	count.page = &Page{ID: page.ID, Title: page.Title, SpaceKey: key}
+	count.views++
+}
+
+func (v *pageViews) top(n int, window time.Duration) []*pageCount {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	v.expire(window)
+
+	counts := make([]*pageCount, 0, len(v.counts))
+	for _, count := range v.counts {
+		counts = append(counts, &pageCount{page: count.page, views: count.views})
+	}
+
+	// most viewed first, then by title
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].views != counts[j].views {
+			return counts[i].views > counts[j].views
+		}
+
+		return counts[i].page.Title < counts[j].page.Title
+	})
+
+	if len(counts) > n {
+		counts = counts[:n]
+	}
+
+	return counts
+}
+
+func (v *pageViews) expire(window time.Duration) {
+	// start over once the window has passed
+	now := time.Now()
+	if window > 0 && now.Sub(v.start) > window {
+		v.counts = nil
+		v.start = now
+	}
+}
+
+func (c *Convergence) TopPages(n int) []*Page {
+	var pages []*Page
+	for _, count := range c.views.top(n, c.TopPagesWindow) {
+		if c.spaceAllowed(count.page.SpaceKey) {
+			pages = append(pages, count.page)
+		}
+	}
+
+	return pages
+}
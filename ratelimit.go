@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pressly/chi"
+	"golang.org/x/time/rate"
+)
+
+const limiterExpiry = 10 * time.Minute
+
+type limiterEntry struct {
+	limiter *rate.Limiter
+	seen    time.Time
+}
+
+type rateLimiter struct {
+	mutex   sync.Mutex
+	entries map[string]*limiterEntry
+	swept   time.Time
+}
+
+func (l *rateLimiter) allow(key string, limit rate.Limit, burst int) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+
+	// forget idle clients from time to time
+	if now.Sub(l.swept) > limiterExpiry {
+		for k, entry := range l.entries {
+			if now.Sub(entry.seen) > limiterExpiry {
+				delete(l.entries, k)
+			}
+		}
+
+		l.swept = now
+	}
+
+	if l.entries == nil {
+		l.entries = make(map[string]*limiterEntry)
+	}
+
+	entry, ok := l.entries[key]
+	if !ok {
+		entry = &limiterEntry{limiter: rate.NewLimiter(limit, burst)}
+		l.entries[key] = entry
+	}
+
+	entry.seen = now
+
+	return entry.limiter.Allow()
+}
+
+func (c *Convergence) limit(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// skip if disabled
+		if c.RateLimit <= 0 {
+			handler(w, r)
+			return
+		}
+
+		key := c.clientIP(r)
+		if c.RateLimitBySpace {
+			key += "|" + c.spaceKey(chi.URLParam(r, "key"))
+		}
+
+		if !c.limiter.allow(key, rate.Limit(c.RateLimit), c.RateBurst) {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+func (c *Convergence) clientIP(r *http.Request) string {
+	// use the address appended by the trusted proxy, clients control the ones before
+	if c.TrustProxy {
+		forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+		if last := strings.TrimSpace(forwarded[len(forwarded)-1]); last != "" {
+			return last
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
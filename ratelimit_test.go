@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		trust     bool
+		forwarded string
+		want      string
+	}{
+		{false, "", "192.0.2.1"},
+		{false, "203.0.113.7", "192.0.2.1"},
+		{true, "", "192.0.2.1"},
+		{true, "203.0.113.7", "203.0.113.7"},
+		{true, "198.51.100.9, 203.0.113.7", "203.0.113.7"},
+		{true, "spoofed,203.0.113.7", "203.0.113.7"},
+		{true, "203.0.113.7, ", "192.0.2.1"},
+	}
+
+	for _, test := range tests {
+		c := &Convergence{TrustProxy: test.trust}
+
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		if test.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", test.forwarded)
+		}
+
+		if got := c.clientIP(r); got != test.want {
+			t.Errorf("clientIP with X-Forwarded-For %q and TrustProxy %v = %q; want %q", test.forwarded, test.trust, got, test.want)
+		}
+	}
+}
+
+func TestProxyRateLimit(t *testing.T) {
+	c := NewConvergence(newTestConfluence(t, spacesHandler), "", "")
+	c.RateLimit = 1
+	c.RateBurst = 2
+	c.TrustProxy = true
+
+	handler := c.proxyMiddleware(http.NotFoundHandler())
+
+	tests := []struct {
+		forwarded string
+		status    int
+	}{
+		{"198.51.100.1, 203.0.113.7", http.StatusOK},
+		{"198.51.100.2, 203.0.113.7", http.StatusOK},
+		{"198.51.100.3, 203.0.113.7", http.StatusTooManyRequests},
+		{"203.0.113.8", http.StatusOK},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/wiki/download/attachments/1/a.txt", nil)
+		r.Header.Set("X-Forwarded-For", test.forwarded)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+
+		if rec.Code != test.status {
+			t.Errorf("GET with X-Forwarded-For %q = %d; want %d", test.forwarded, rec.Code, test.status)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+func init() {
+	// values are stored behind an interface and must be known to gob
+	gob.Register(&Space{})
+	gob.Register([]*Space{})
+	gob.Register(&Page{})
+	gob.Register([]*Page{})
+	gob.Register(&PageList{})
+	gob.Register([]string{})
+	gob.Register([]*Comment{})
+	gob.Register(&User{})
+	gob.Register([]*AttachmentMeta{})
+	gob.Register(&Attachment{})
+	gob.Register(&Response{})
+	gob.Register(notFoundEntry{})
+	gob.Register(&staleEntry{})
+}
+
+type redisEntry struct {
+	Value interface{}
+}
+
+type RedisCache struct {
+	Prefix string
+
+	pool *redis.Pool
+}
+
+func NewRedisCache(url string) *RedisCache {
+	return &RedisCache{
+		Prefix: "convergence:",
+		pool: &redis.Pool{
+			MaxIdle:     10,
+			IdleTimeout: 5 * time.Minute,
+			Dial: func() (redis.Conn, error) {
+				return redis.DialURL(url)
+			},
+		},
+	}
+}
+
+func (r *RedisCache) Get(key string) (interface{}, bool) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	data, err := redis.Bytes(conn.Do("GET", r.Prefix+key))
+	if err != nil {
+		// missing keys are not an error
+		if err != redis.ErrNil {
+			fmt.Printf("Cache Error: %s\n", err.Error())
+		}
+
+		return nil, false
+	}
+
+	var entry redisEntry
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&entry); err != nil {
+		fmt.Printf("Cache Error: %s\n", err.Error())
+		return nil, false
+	}
+
+	return entry.Value, true
+}
+
+func (r *RedisCache) Set(key string, value interface{}, ttl time.Duration) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(redisEntry{Value: value}); err != nil {
+		fmt.Printf("Cache Error: %s\n", err.Error())
+		return
+	}
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	args := []interface{}{r.Prefix + key, buf.Bytes()}
+	if ttl > 0 {
+		args = append(args, "PX", int64(ttl/time.Millisecond))
+	}
+
+	if _, err := conn.Do("SET", args...); err != nil {
+		fmt.Printf("Cache Error: %s\n", err.Error())
+	}
+}
+
+func (r *RedisCache) Delete(key string) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	if _, err := conn.Do("DEL", r.Prefix+key); err != nil {
+		fmt.Printf("Cache Error: %s\n", err.Error())
+	}
+}
+
+func (r *RedisCache) Keys(prefix string) []string {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	// keys may contain glob characters
+	pattern := r.Prefix + prefix
+	for _, char := range []string{`\`, "*", "?", "[", "]"} {
+		pattern = strings.Replace(pattern, char, `\`+char, -1)
+	}
+
+	var keys []string
+	cursor := 0
+
+	for {
+		values, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", pattern+"*", "COUNT", 100))
+		if err != nil {
+			fmt.Printf("Cache Error: %s\n", err.Error())
+			return keys
+		}
+
+		var batch []string
+		if _, err := redis.Scan(values, &cursor, &batch); err != nil {
+			fmt.Printf("Cache Error: %s\n", err.Error())
+			return keys
+		}
+
+		for _, key := range batch {
+			keys = append(keys, strings.TrimPrefix(key, r.Prefix))
+		}
+
+		// a zero cursor ends the iteration
+		if cursor == 0 {
+			return keys
+		}
+	}
+}
+
+func (r *RedisCache) Flush() {
+	for _, key := range r.Keys("") {
+		r.Delete(key)
+	}
+}
+
+func (r *RedisCache) TTL(key string) (time.Duration, bool) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	// negative values mark missing or persistent keys
+	millis, err := redis.Int64(conn.Do("PTTL", r.Prefix+key))
+	if err != nil || millis < 0 {
+		return 0, false
+	}
+
+	return time.Duration(millis) * time.Millisecond, true
+}
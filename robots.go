@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+const DefaultRobots = `User-agent: *
+Disallow: /search
+Disallow: /label/
+Disallow: /reset
+Disallow: /api/
+Disallow: /admin/
+Disallow: /cache/
+`
+
+func (c *Convergence) viewRobots(w http.ResponseWriter, r *http.Request) {
+	// an empty file hides the route
+	if c.Robots == "" {
+		c.showError(w, r, ErrNotFound)
+		return
+	}
+
+	robots := c.Robots
+	if !strings.HasSuffix(robots, "\n") {
+		robots += "\n"
+	}
+
+	// point crawlers to all pages
+	if !strings.Contains(strings.ToLower(robots), "sitemap:") {
+		robots += "Sitemap: " + requestBase(r) + "/sitemap.xml\n"
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", cacheControl(c.AssetMaxAge))
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(robots))
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+type sitemapEntry struct {
+	Path      string
+	UpdatedAt time.Time
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+func (c *Convergence) viewSitemap(w http.ResponseWriter, r *http.Request) {
+	entries, err := c.sitemapEntries(r.Context())
+	if err != nil {
+		c.showError(w, r, err)
+		return
+	}
+
+	base := requestBase(r)
+
+	set := sitemapURLSet{
+		Xmlns: "http://www.sitemaps.org/schemas/sitemap/0.9",
+	}
+
+	for _, entry := range entries {
+		loc := sitemapURL{Loc: base + entry.Path}
+		if !entry.UpdatedAt.IsZero() {
+			loc.LastMod = entry.UpdatedAt.UTC().Format(time.RFC3339)
+		}
+
+		set.URLs = append(set.URLs, loc)
+	}
+
+	data, err := xml.Marshal(set)
+	if err != nil {
+		c.showError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	w.Write(data)
+}
+
+func (c *Convergence) sitemapEntries(ctx context.Context) ([]sitemapEntry, error) {
+	c.sitemapMutex.Lock()
+	defer c.sitemapMutex.Unlock()
+
+	// reuse recently built sitemap
+	if c.sitemap != nil && time.Since(c.sitemapTime) < c.SitemapTTL {
+		return c.sitemap, nil
+	}
+
+	spaces, err := c.confluence.GetSpaces(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]sitemapEntry, 0)
+
+	for _, space := range spaces {
+		if !c.spaceAllowed(space.Key) {
+			continue
+		}
+
+		entries = append(entries, sitemapEntry{Path: "/" + space.Key})
+
+		if space.Homepage.ID == "" {
+			continue
+		}
+
+		// walk the page tree below the homepage
+		visited := map[string]bool{space.Homepage.ID: true}
+		queue := []string{space.Homepage.ID}
+
+		for len(queue) > 0 {
+			children, err := c.confluence.GetChildPages(ctx, queue[0])
+			queue = queue[1:]
+
+			// skip subtrees that cannot be listed
+			if err != nil {
+				fmt.Printf("Sitemap Error: %s\n", err.Error())
+				continue
+			}
+
+			for _, child := range children {
+				if visited[child.ID] {
+					continue
+				}
+
+				visited[child.ID] = true
+				queue = append(queue, child.ID)
+
+				entries = append(entries, sitemapEntry{
+					Path:      "/" + space.Key + "/" + child.ID + "/" + url.QueryEscape(child.Title),
+					UpdatedAt: child.UpdatedAt,
+				})
+			}
+		}
+	}
+
+	c.sitemap = entries
+	c.sitemapTime = time.Now()
+
+	return entries, nil
+}
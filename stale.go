@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+)
+
+type staleWriter struct {
+	http.ResponseWriter
+
+	trace   *CacheTrace
+	written bool
+}
+
+func (w *staleWriter) WriteHeader(status int) {
+	// warn clients about expired content before the headers are sent
+	if !w.written && w.trace.ServedStale() {
+		w.Header().Set("Warning", `110 - "Response is Stale"`)
+	}
+
+	w.written = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *staleWriter) Write(data []byte) (int, error) {
+	if !w.written {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	return w.ResponseWriter.Write(data)
+}
+
+func (c *Convergence) staleMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// skip if disabled
+		trace, ok := r.Context().Value(cacheTraceKey{}).(*CacheTrace)
+		if !ok || c.confluence.StaleIfErrorTTL <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		next.ServeHTTP(&staleWriter{ResponseWriter: w, trace: trace}, r)
+	})
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var textSpace = regexp.MustCompile(`[ \t\r\n\f\x{00a0}]+`)
+var textGap = regexp.MustCompile(`\n{3,}`)
+
+func toPlainText(body string) string {
+	nodes, err := html.ParseFragment(strings.NewReader(body), &html.Node{
+		Type:     html.ElementNode,
+		Data:     "body",
+		DataAtom: atom.Body,
+	})
+	if err != nil {
+		return body
+	}
+
+	var buf strings.Builder
+	for _, node := range nodes {
+		textNode(&buf, node, false)
+	}
+
+	// trim lines and keep at most one blank line between paragraphs
+	lines := strings.Split(buf.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+
+	return strings.TrimSpace(textGap.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
+
+func textNode(buf *strings.Builder, node *html.Node, pre bool) {
+	switch node.Type {
+	case html.TextNode:
+		// entities are already decoded by the parser
+		if pre {
+			buf.WriteString(node.Data)
+		} else {
+			buf.WriteString(textSpace.ReplaceAllString(node.Data, " "))
+		}
+
+		return
+	case html.ElementNode:
+	default:
+		return
+	}
+
+	switch node.DataAtom {
+	case atom.Script, atom.Style, atom.Template:
+		return
+	case atom.Br:
+		buf.WriteString("\n")
+		return
+	case atom.Td, atom.Th:
+		buf.WriteString(" ")
+	case atom.Li, atom.Tr, atom.Dt, atom.Dd:
+		buf.WriteString("\n")
+	case atom.P, atom.Div, atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6,
+		atom.Ul, atom.Ol, atom.Dl, atom.Table, atom.Blockquote, atom.Pre, atom.Hr:
+		buf.WriteString("\n\n")
+		defer buf.WriteString("\n\n")
+	}
+
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		textNode(buf, child, pre || node.DataAtom == atom.Pre)
+	}
+}
+
+func (c *Convergence) viewText(w http.ResponseWriter, r *http.Request, page *Page) {
+	if c.notModified(w, r, etag("text", page.ID, page.Title, page.Body, strconv.Itoa(page.Version))) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(page.Title + "\n\n" + toPlainText(page.Body) + "\n"))
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/pressly/chi"
+)
+
+func decodeTinyLink(tiny string) (string, bool) {
+	// tiny links are page ids as little endian base64 without trailing zeros
+	str := strings.NewReplacer("-", "/", "_", "+").Replace(tiny)
+	if str == "" || len(str) > 11 {
+		return "", false
+	}
+
+	data, err := base64.RawStdEncoding.DecodeString(str + strings.Repeat("A", 11-len(str)))
+	if err != nil {
+		return "", false
+	}
+
+	id := binary.LittleEndian.Uint64(data)
+	if id == 0 {
+		return "", false
+	}
+
+	return strconv.FormatUint(id, 10), true
+}
+
+func (c *Confluence) ResolveTinyLink(ctx context.Context, tiny string) (*Page, error) {
+	id, ok := decodeTinyLink(tiny)
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return c.GetPageRef(ctx, id)
+}
+
+func (c *Confluence) GetPageRef(ctx context.Context, id string) (*Page, error) {
+	cacheKey := "ref-" + id
+
+	if value, ok := c.cache().Get(cacheKey); ok {
+		c.cacheHit(ctx, cacheKey)
+		if _, ok := value.(notFoundEntry); ok {
+			return nil, ErrNotFound
+		}
+
+		return value.(*Page), nil
+	}
+
+	c.cacheMiss(ctx, cacheKey)
+
+	// only the space and title are needed to link the page
+	var page *Page
+	var err error
+
+	if c.v2() {
+		page, err = c.fetchPageRefV2(ctx, id)
+	} else {
+		page, err = c.fetchPageRefV1(ctx, id)
+	}
+	if err != nil {
+		c.cacheNotFound(cacheKey, err)
+		return nil, err
+	}
+
+	c.cacheContent(cacheKey, page)
+
+	return page, nil
+}
+
+func (c *Confluence) fetchPageRefV1(ctx context.Context, id string) (*Page, error) {
+	_, res, err := c.end(ctx, c.agent().Get(c.url("content/"+id)).
+		Set("Accept", "application/json, */*").
+		Query("expand=space"))
+	if err != nil {
+		return nil, err
+	}
+
+	obj, err := parseJSON(res)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.parsePage(obj)
+}
+
+func (c *Confluence) fetchPageRefV2(ctx context.Context, id string) (*Page, error) {
+	kind := "page"
+
+	_, res, err := c.end(ctx, c.agent().Get(c.urlV2("pages/"+url.PathEscape(id))).
+		Set("Accept", "application/json, */*"))
+
+	// blog posts have a separate endpoint
+	if errors.Is(err, ErrNotFound) {
+		kind = "blogpost"
+		_, res, err = c.end(ctx, c.agent().Get(c.urlV2("blogposts/"+url.PathEscape(id))).
+			Set("Accept", "application/json, */*"))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	obj, err := parseJSON(res)
+	if err != nil {
+		return nil, err
+	}
+
+	page, err := c.parsePageV2(ctx, obj)
+	if err != nil {
+		return nil, err
+	}
+
+	page.Type = kind
+
+	return page, nil
+}
+
+func (c *Convergence) viewTinyLink(w http.ResponseWriter, r *http.Request) {
+	page, err := c.confluence.ResolveTinyLink(r.Context(), chi.URLParam(r, "tiny"))
+
+	// pages without a space cannot be linked locally
+	if err == nil && (page.SpaceKey == "" || !c.spaceAllowed(page.SpaceKey)) {
+		err = ErrNotFound
+	}
+	if err != nil {
+		c.showError(w, r, err)
+		return
+	}
+
+	// redirect to canonical page url
+	path := "/" + url.PathEscape(page.SpaceKey) + "/" + url.PathEscape(page.ID) + "/" + url.QueryEscape(page.Title)
+	if page.Type == "blogpost" {
+		path = "/blog" + path
+	}
+
+	http.Redirect(w, r, path, http.StatusFound)
+}
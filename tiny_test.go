@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pressly/chi"
+)
+
+func TestDecodeTinyLink(t *testing.T) {
+	tests := []struct {
+		tiny string
+		id   string
+		ok   bool
+	}{
+		{"AQAAAA", "1", true},
+		{"AgAAAA", "2", true},
+		{"ZoCx", "11632742", true},
+		{"", "", false},
+		{"AAAA", "", false},
+		{"AAAAAAAAAAAA", "", false},
+		{"!!", "", false},
+	}
+
+	for _, test := range tests {
+		id, ok := decodeTinyLink(test.tiny)
+		if id != test.id || ok != test.ok {
+			t.Errorf("decodeTinyLink(%q) = %q, %v; want %q, %v", test.tiny, id, ok, test.id, test.ok)
+		}
+	}
+}
+
+func TestViewTinyLink(t *testing.T) {
+	confluence := newTestConfluence(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.URL.Path {
+		case "/wiki/rest/api/content/1":
+			w.Write([]byte(`{"id":"1","type":"page","title":"Some Page","space":{"key":"ENG"}}`))
+		case "/wiki/rest/api/content/2":
+			w.Write([]byte(`{"id":"2","type":"blogpost","title":"News","space":{"key":"ENG"}}`))
+		case "/wiki/rest/api/content/3":
+			w.Write([]byte(`{"id":"3","type":"page","title":"Orphan"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"statusCode":404}`))
+		}
+	})
+
+	c := NewConvergence(confluence, "", "")
+
+	router := chi.NewRouter()
+	router.Get("/x/:tiny", c.viewTinyLink)
+
+	tests := []struct {
+		path     string
+		status   int
+		location string
+	}{
+		{"/x/AQAAAA", http.StatusFound, "/ENG/1/Some+Page"},
+		{"/x/AgAAAA", http.StatusFound, "/blog/ENG/2/News"},
+		{"/x/AwAAAA", http.StatusNotFound, ""},
+		{"/x/BAAAAA", http.StatusNotFound, ""},
+	}
+
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("GET", test.path, nil))
+
+		if rec.Code != test.status || rec.Header().Get("Location") != test.location {
+			t.Errorf("GET %s = %d %q; want %d %q", test.path, rec.Code, rec.Header().Get("Location"), test.status, test.location)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const tocClass = "cv-toc"
+
+var headingSlug = regexp.MustCompile(`[^a-z0-9]+`)
+
+type TOCEntry struct {
+	Level int    `json:"level"`
+	ID    string `json:"id"`
+	Title string `json:"title"`
+}
+
+func buildTOC(body string, depth int) (string, []TOCEntry) {
+	// skip bodies without headings
+	if !strings.Contains(body, "<h") {
+		return body, nil
+	}
+
+	nodes, err := html.ParseFragment(strings.NewReader(body), &html.Node{
+		Type:     html.ElementNode,
+		Data:     "body",
+		DataAtom: atom.Body,
+	})
+	if err != nil {
+		return body, nil
+	}
+
+	var toc []TOCEntry
+	var headings, links, placeholders []*html.Node
+	ids := make(map[string]bool)
+
+	var walk func(*html.Node)
+	walk = func(node *html.Node) {
+		if node.Type == html.ElementNode {
+			// slugs must not collide with other ids
+			if headingLevel(node) > 0 {
+				headings = append(headings, node)
+			} else if id := nodeAttr(node, "id"); id != "" {
+				ids[id] = true
+			}
+
+			if node.DataAtom == atom.A && strings.HasPrefix(nodeAttr(node, "href"), "#") {
+				links = append(links, node)
+			}
+
+			// confluence leaves client side tocs empty
+			if node.DataAtom == atom.Div && strings.Contains(" "+nodeAttr(node, "class")+" ", " toc-macro ") {
+				placeholders = append(placeholders, node)
+				return
+			}
+		}
+
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+
+	for _, node := range nodes {
+		walk(node)
+	}
+
+	if len(headings) == 0 {
+		return body, nil
+	}
+
+	// give all headings stable ids
+	anchors := make(map[string]string)
+	for _, node := range headings {
+		id := headingID(node, ids, anchors)
+
+		if level := headingLevel(node); level <= depth {
+			toc = append(toc, TOCEntry{
+				Level: level,
+				ID:    id,
+				Title: strings.Join(strings.Fields(nodeText(node)), " "),
+			})
+		}
+	}
+
+	// point links within the page to the new ids
+	for _, link := range links {
+		if id, ok := anchorTarget(strings.TrimPrefix(nodeAttr(link, "href"), "#"), anchors); ok {
+			setNodeAttr(link, "href", "#"+id)
+		}
+	}
+
+	// fill placeholders with the generated list
+	if len(toc) > 0 {
+		for _, placeholder := range placeholders {
+			for placeholder.FirstChild != nil {
+				placeholder.RemoveChild(placeholder.FirstChild)
+			}
+
+			placeholder.AppendChild(tocList(toc))
+		}
+	}
+
+	var buf bytes.Buffer
+	for _, node := range nodes {
+		if err := html.Render(&buf, node); err != nil {
+			return body, toc
+		}
+	}
+
+	return buf.String(), toc
+}
+
+func headingLevel(node *html.Node) int {
+	switch node.DataAtom {
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		level, _ := strconv.Atoi(node.Data[1:])
+		return level
+	}
+
+	return 0
+}
+
+func headingID(node *html.Node, ids map[string]bool, anchors map[string]string) string {
+	title := nodeText(node)
+
+	slug := strings.Trim(headingSlug.ReplaceAllString(strings.ToLower(title), "-"), "-")
+	if slug == "" {
+		slug = "section"
+	}
+
+	// make ids unique within the body
+	id := slug
+	for i := 2; ids[id]; i++ {
+		id = slug + "-" + strconv.Itoa(i)
+	}
+
+	ids[id] = true
+
+	// keep links to ids generated by confluence working
+	if original := nodeAttr(node, "id"); original != "" && original != id {
+		anchors[original] = id
+		ids[original] = true
+
+		node.InsertBefore(&html.Node{
+			Type:     html.ElementNode,
+			Data:     "span",
+			DataAtom: atom.Span,
+			Attr:     []html.Attribute{{Key: "id", Val: original}},
+		}, node.FirstChild)
+	}
+
+	// also resolve anchors written as the plain title
+	if compact := compactAnchor(title); compact != "" {
+		if _, ok := anchors[compact]; !ok {
+			anchors[compact] = id
+		}
+	}
+
+	setNodeAttr(node, "id", id)
+
+	return id
+}
+
+func anchorTarget(fragment string, anchors map[string]string) (string, bool) {
+	if unescaped, err := url.PathUnescape(fragment); err == nil {
+		fragment = unescaped
+	}
+
+	if id, ok := anchors[fragment]; ok {
+		return id, true
+	}
+
+	if id, ok := anchors[compactAnchor(fragment)]; ok {
+		return id, true
+	}
+
+	// confluence prefixes anchors with the page title
+	if i := strings.Index(fragment, "-"); i >= 0 {
+		if id, ok := anchors[compactAnchor(fragment[i+1:])]; ok {
+			return id, true
+		}
+	}
+
+	return "", false
+}
+
+func compactAnchor(text string) string {
+	return headingSlug.ReplaceAllString(strings.ToLower(text), "")
+}
+
+func tocList(toc []TOCEntry) *html.Node {
+	list := &html.Node{
+		Type:     html.ElementNode,
+		Data:     "ul",
+		DataAtom: atom.Ul,
+		Attr:     []html.Attribute{{Key: "class", Val: tocClass}},
+	}
+
+	for _, entry := range toc {
+		link := &html.Node{
+			Type:     html.ElementNode,
+			Data:     "a",
+			DataAtom: atom.A,
+			Attr:     []html.Attribute{{Key: "href", Val: "#" + entry.ID}},
+		}
+		link.AppendChild(&html.Node{Type: html.TextNode, Data: entry.Title})
+
+		item := &html.Node{
+			Type:     html.ElementNode,
+			Data:     "li",
+			DataAtom: atom.Li,
+			Attr:     []html.Attribute{{Key: "class", Val: tocClass + "-" + strconv.Itoa(entry.Level)}},
+		}
+		item.AppendChild(link)
+
+		list.AppendChild(item)
+	}
+
+	return list
+}
+
+func sidebarTOC(page *Page) []TOCEntry {
+	// skip if the body already shows the list
+	if len(page.TOC) < 2 || strings.Contains(page.Body, `class="`+tocClass+`"`) {
+		return nil
+	}
+
+	return page.TOC
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBuildTOC(t *testing.T) {
+	tests := []struct {
+		body string
+		toc  []TOCEntry
+		ids  []string
+	}{
+		{
+			`<h1>Intro</h1><h2>Setup</h2>`,
+			[]TOCEntry{{1, "intro", "Intro"}, {2, "setup", "Setup"}},
+			[]string{`id="intro"`, `id="setup"`},
+		},
+		{
+			`<h2>Usage</h2><h2>Usage</h2><h2>Usage</h2>`,
+			[]TOCEntry{{2, "usage", "Usage"}, {2, "usage-2", "Usage"}, {2, "usage-3", "Usage"}},
+			[]string{`id="usage"`, `id="usage-2"`, `id="usage-3"`},
+		},
+		{
+			`<p id="notes">x</p><h2>Notes</h2>`,
+			[]TOCEntry{{2, "notes-2", "Notes"}},
+			[]string{`id="notes"`, `id="notes-2"`},
+		},
+		{
+			`<h2>!!!</h2><h2></h2>`,
+			[]TOCEntry{{2, "section", "!!!"}, {2, "section-2", ""}},
+			[]string{`id="section"`, `id="section-2"`},
+		},
+		{
+			`<h2 id="Page-Usage">Usage</h2><a href="#Page-Usage">up</a>`,
+			[]TOCEntry{{2, "usage", "Usage"}},
+			[]string{`id="usage"`, `href="#usage"`},
+		},
+	}
+
+	for _, test := range tests {
+		body, toc := buildTOC(test.body, 3)
+		if !reflect.DeepEqual(toc, test.toc) {
+			t.Errorf("buildTOC(%q) = %v; want %v", test.body, toc, test.toc)
+		}
+
+		for _, id := range test.ids {
+			if !strings.Contains(body, id) {
+				t.Errorf("buildTOC(%q) = %q; want %s", test.body, body, id)
+			}
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/pressly/chi"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/IAD-ZHDK/Convergence"
+
+var tracePropagator = propagation.TraceContext{}
+
+func tracer(provider trace.TracerProvider) trace.Tracer {
+	// the global provider does nothing unless configured
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+
+	return provider.Tracer(tracerName)
+}
+
+func (c *Convergence) traceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// continue traces of calling services
+		ctx := tracePropagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+		ctx, span := tracer(c.TracerProvider).Start(ctx, "HTTP "+r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.method", r.Method),
+				attribute.String("http.target", r.URL.RequestURI()),
+			))
+		defer span.End()
+
+		// share cache usage with the access log
+		cache, ok := ctx.Value(cacheTraceKey{}).(*CacheTrace)
+		if !ok {
+			ctx, cache = WithCacheTrace(ctx)
+		}
+
+		writer := &statusWriter{ResponseWriter: w}
+
+		next.ServeHTTP(writer, r.WithContext(ctx))
+
+		// handlers that never write respond with 200
+		if writer.status == 0 {
+			writer.status = http.StatusOK
+		}
+
+		span.SetAttributes(
+			attribute.Int("http.status_code", writer.status),
+			attribute.Bool("convergence.cache_hit", cache.Cached()),
+		)
+
+		if writer.status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(writer.status))
+		}
+	})
+}
+
+func traceRoute(r *http.Request, route string) {
+	span := trace.SpanFromContext(r.Context())
+	if !span.IsRecording() {
+		return
+	}
+
+	// search and label views take the space as a query
+	key := chi.URLParam(r, "key")
+	if key == "" {
+		key = r.URL.Query().Get("space")
+	}
+
+	span.SetName(route)
+	span.SetAttributes(
+		attribute.String("convergence.route", route),
+		attribute.String("convergence.space_key", key),
+		attribute.String("convergence.page_id", chi.URLParam(r, "id")),
+	)
+}
+
+func (c *Confluence) startSpan(ctx context.Context, method, url string, header http.Header) trace.Span {
+	_, span := tracer(c.TracerProvider).Start(ctx, "confluence "+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.method", method),
+			attribute.String("http.url", url),
+		))
+
+	// let confluence join the trace
+	tracePropagator.Inject(trace.ContextWithSpan(ctx, span), propagation.HeaderCarrier(header))
+
+	return span
+}
+
+func endSpan(span trace.Span, status int, err error) {
+	if status > 0 {
+		span.SetAttributes(attribute.Int("http.status_code", status))
+	}
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	} else if status >= 400 {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+
+	span.End()
+}
+
+func traceCache(ctx context.Context, event, key string) {
+	trace.SpanFromContext(ctx).AddEvent(event, trace.WithAttributes(attribute.String("convergence.cache_key", key)))
+}
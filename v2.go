@@ -0,0 +1,441 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Jeffail/gabs"
+)
+
+func (c *Confluence) v2() bool {
+	return c.APIVersion == "v2"
+}
+
+func (c *Confluence) urlV2(path string) string {
+	return c.endpoint("api/v2/" + strings.TrimPrefix(path, "/"))
+}
+
+func (c *Confluence) getResultsV2(ctx context.Context, endpoint string, limit int, fn func(*gabs.Container) error, query ...string) error {
+	for cursor, count := "", 0; limit <= 0 || count < limit; {
+		// get at most the remaining amount
+		size := c.PageSize
+		if limit > 0 && limit-count < size {
+			size = limit - count
+		}
+
+		agent := c.agent().Get(endpoint).
+			Set("Accept", "application/json, */*").
+			Query("limit=" + strconv.Itoa(size))
+
+		if cursor != "" {
+			agent.Query("cursor=" + url.QueryEscape(cursor))
+		}
+
+		for _, q := range query {
+			agent.Query(q)
+		}
+
+		_, res, err := c.end(ctx, agent)
+		if err != nil {
+			return err
+		}
+
+		json, err := parseJSON(res)
+		if err != nil {
+			return err
+		}
+
+		results, err := json.Path("results").Children()
+		if err != nil {
+			return err
+		}
+
+		for _, obj := range results {
+			if err := fn(obj); err != nil {
+				return err
+			}
+		}
+
+		count += len(results)
+
+		// follow the cursor of the next link
+		next, ok := getString(json, "_links.next")
+		if !ok || len(results) == 0 {
+			break
+		}
+
+		if cursor = nextCursor(next); cursor == "" {
+			break
+		}
+	}
+
+	return nil
+}
+
+func nextCursor(link string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+
+	return u.Query().Get("cursor")
+}
+
+func (c *Confluence) fetchSpacesV2(ctx context.Context) ([]*Space, error) {
+	var spaces []*Space
+
+	query := []string{"description-format=view"}
+
+	// filter by space type
+	if c.SpaceType != "" {
+		query = append(query, "type="+url.QueryEscape(c.SpaceType))
+	}
+
+	err := c.getResultsV2(ctx, c.urlV2("spaces"), 0, func(obj *gabs.Container) error {
+		space, err := c.parseSpaceV2(obj)
+		if err != nil {
+			return err
+		}
+
+		spaces = append(spaces, space)
+
+		return nil
+	}, query...)
+	if err != nil {
+		return nil, err
+	}
+
+	return spaces, nil
+}
+
+func (c *Confluence) fetchSpaceV2(ctx context.Context, key string) (*Space, error) {
+	_, data, err := c.end(ctx, c.agent().Get(c.urlV2("spaces")).
+		Set("Accept", "application/json, */*").
+		Query("keys="+url.QueryEscape(key)).
+		Query("description-format=view"))
+	if err != nil {
+		return nil, err
+	}
+
+	json, err := parseJSON(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// unknown keys yield an empty list
+	results, err := json.Path("results").Children()
+	if err != nil || len(results) == 0 {
+		return nil, ErrNotFound
+	}
+
+	space, err := c.parseSpaceV2(results[0])
+	if err != nil {
+		return nil, err
+	}
+
+	// the homepage is only referenced by id
+	if space.Homepage.ID != "" && hasExpand(c.SpaceExpand, "homepage.body.view") {
+		_, data, err := c.end(ctx, c.agent().Get(c.urlV2("pages/"+space.Homepage.ID)).
+			Set("Accept", "application/json, */*").
+			Query("body-format=view"))
+		if err != nil {
+			return nil, err
+		}
+
+		obj, err := parseJSON(data)
+		if err != nil {
+			return nil, err
+		}
+
+		space.Homepage.Title, _ = getString(obj, "title")
+
+		if body, ok := getString(obj, "body.view.value"); ok {
+			space.Homepage.Body = c.processBody(body)
+		}
+	}
+
+	return space, nil
+}
+
+func (c *Confluence) parseSpaceV2(obj *gabs.Container) (*Space, error) {
+	space := &Space{}
+
+	var ok bool
+	if space.Key, ok = getString(obj, "key"); !ok {
+		return nil, errors.New("space without key")
+	}
+
+	if space.Name, ok = getString(obj, "name"); !ok {
+		return nil, errors.New("space without name: " + space.Key)
+	}
+
+	// pages only reference their space by id
+	if id, ok := getID(obj, "id"); ok {
+		c.spaceKeys.Store(id, space.Key)
+	}
+
+	// description and homepage are optional
+	if description, ok := getString(obj, "description.view.value"); ok {
+		space.Description = c.processBody(description)
+	}
+
+	space.Homepage.ID, _ = getID(obj, "homepageId")
+
+	return space, nil
+}
+
+func (c *Confluence) spaceKeyV2(ctx context.Context, id string) (string, error) {
+	if key, ok := c.spaceKeys.Load(id); ok {
+		return key.(string), nil
+	}
+
+	_, data, err := c.end(ctx, c.agent().Get(c.urlV2("spaces/"+url.PathEscape(id))).
+		Set("Accept", "application/json, */*"))
+	if err != nil {
+		return "", err
+	}
+
+	obj, err := parseJSON(data)
+	if err != nil {
+		return "", err
+	}
+
+	space, err := c.parseSpaceV2(obj)
+	if err != nil {
+		return "", err
+	}
+
+	return space.Key, nil
+}
+
+func (c *Confluence) fetchContentV2(ctx context.Context, kind, id string, expand []string) (*Page, error) {
+	endpoint := c.urlV2(kind + "s/" + url.PathEscape(id))
+	expanded := strings.Split(c.pageExpand(expand), ",")
+
+	agent := c.agent().Get(endpoint).
+		Set("Accept", "application/json, */*").
+		Query("body-format=view")
+
+	if hasExpand(expanded, "metadata.labels") {
+		agent.Query("include-labels=true")
+	}
+
+	_, res, err := c.end(ctx, agent)
+	if err != nil {
+		return nil, err
+	}
+
+	obj, err := parseJSON(res)
+	if err != nil {
+		return nil, err
+	}
+
+	page, err := c.parsePageV2(ctx, obj)
+	if err != nil {
+		return nil, err
+	}
+
+	// separate endpoints per type
+	page.Type = kind
+
+	// only one body format is returned per request
+	if c.FetchStorage || hasExpand(expanded, "body.storage") {
+		_, res, err := c.end(ctx, c.agent().Get(endpoint).
+			Set("Accept", "application/json, */*").
+			Query("body-format=storage"))
+		if err != nil {
+			return nil, err
+		}
+
+		obj, err := parseJSON(res)
+		if err != nil {
+			return nil, err
+		}
+
+		page.Storage, _ = getString(obj, "body.storage.value")
+	}
+
+	// the author is only referenced by id
+	if page.UpdatedByID != "" {
+		if user, err := c.GetUser(ctx, page.UpdatedByID); err == nil {
+			page.UpdatedBy = user.DisplayName
+		}
+	}
+
+	if kind == "page" && hasExpand(expanded, "ancestors") {
+		page.Ancestors, err = c.getAncestorsV2(ctx, page.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return page, nil
+}
+
+func (c *Confluence) getAncestorsV2(ctx context.Context, id string) ([]*Page, error) {
+	var ids []string
+
+	// ancestors are ordered from root to parent
+	err := c.getResultsV2(ctx, c.urlV2("pages/"+url.PathEscape(id)+"/ancestors"), 0, func(obj *gabs.Container) error {
+		if id, ok := getID(obj, "id"); ok {
+			ids = append(ids, id)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// titles have to be looked up separately
+	ancestors := make([]*Page, 0, len(ids))
+	for _, id := range ids {
+		_, res, err := c.end(ctx, c.agent().Get(c.urlV2("pages/"+url.PathEscape(id))).
+			Set("Accept", "application/json, */*"))
+		if err != nil {
+			return nil, err
+		}
+
+		obj, err := parseJSON(res)
+		if err != nil {
+			return nil, err
+		}
+
+		if title, ok := getString(obj, "title"); ok {
+			ancestors = append(ancestors, &Page{ID: id, Title: title})
+		}
+	}
+
+	return ancestors, nil
+}
+
+func (c *Confluence) getChildPagesV2(ctx context.Context, id string) ([]*Page, error) {
+	pages := make([]*Page, 0)
+
+	err := c.getResultsV2(ctx, c.urlV2("pages/"+url.PathEscape(id)+"/children"), 0, func(obj *gabs.Container) error {
+		page, err := c.parsePageV2(ctx, obj)
+		if err != nil {
+			return err
+		}
+
+		pages = append(pages, page)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return pages, nil
+}
+
+func (c *Confluence) getPageLabelsV2(ctx context.Context, id string) ([]string, error) {
+	labels := make([]string, 0)
+
+	err := c.getResultsV2(ctx, c.urlV2("pages/"+url.PathEscape(id)+"/labels"), 0, func(obj *gabs.Container) error {
+		if name, ok := getString(obj, "name"); ok {
+			labels = append(labels, name)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return labels, nil
+}
+
+func (c *Confluence) parsePageV2(ctx context.Context, obj *gabs.Container) (*Page, error) {
+	page := &Page{}
+
+	var ok bool
+	if page.ID, ok = getID(obj, "id"); !ok {
+		return nil, ErrNotFound
+	}
+
+	if page.Title, ok = getString(obj, "title"); !ok {
+		return nil, errors.New("page without title: " + page.ID)
+	}
+
+	// bodies, space and labels are optional
+	if body, ok := getString(obj, "body.view.value"); ok {
+		page.Body, page.TOC = buildTOC(c.processBody(body), c.TOCDepth)
+	}
+
+	if spaceID, ok := getID(obj, "spaceId"); ok {
+		key, err := c.spaceKeyV2(ctx, spaceID)
+		if err != nil {
+			return nil, err
+		}
+
+		page.SpaceKey = key
+	}
+
+	// remember the space for requests by id
+	if page.SpaceKey != "" && len(c.SpaceCredentials) > 0 {
+		c.pageSpaces.Store(page.ID, page.SpaceKey)
+	}
+
+	if labels, err := obj.Path("labels.results").Children(); err == nil {
+		page.Labels = make([]string, 0, len(labels))
+		for _, label := range labels {
+			if name, ok := getString(label, "name"); ok {
+				page.Labels = append(page.Labels, name)
+			}
+		}
+	}
+
+	// versions name the author by account id only
+	if number, ok := obj.Path("version.number").Data().(float64); ok {
+		page.Version = int(number)
+	}
+
+	page.UpdatedByID, _ = getString(obj, "version.authorId")
+
+	if when, ok := getString(obj, "version.createdAt"); ok {
+		page.UpdatedAt, _ = time.Parse(time.RFC3339, when)
+	}
+
+	page.Ancestors = make([]*Page, 0)
+
+	// link to the web ui
+	base, ok := getString(obj, "_links.base")
+	if !ok {
+		base = c.baseURL + c.ContextPath
+	}
+
+	if webui, ok := getString(obj, "_links.webui"); ok {
+		page.Link = base + webui
+	} else {
+		page.Link = base + "/pages/viewpage.action?pageId=" + url.QueryEscape(page.ID)
+	}
+
+	return page, nil
+}
+
+func getID(obj *gabs.Container, path string) (string, bool) {
+	// ids are strings but may be sent as numbers
+	switch value := obj.Path(path).Data().(type) {
+	case string:
+		return value, value != ""
+	case float64:
+		return strconv.FormatInt(int64(value), 10), true
+	}
+
+	return "", false
+}
+
+func hasExpand(expand []string, name string) bool {
+	for _, item := range expand {
+		if strings.TrimSpace(item) == name {
+			return true
+		}
+	}
+
+	return false
+}
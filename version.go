@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/parnurzeal/gorequest"
+)
+
+func (c *Confluence) currentPage(ctx context.Context, kind, key, cacheKey string) (*Page, bool) {
+	// expired pages are kept a while to check their version
+	value, ok := c.cache().Get(cacheKey)
+	if !ok {
+		return nil, false
+	}
+
+	entry, ok := value.(*staleEntry)
+	if !ok {
+		return nil, false
+	}
+
+	cached, ok := entry.Value.(*Page)
+	if !ok || cached.Version == 0 {
+		return nil, false
+	}
+
+	// fall back to a full fetch on errors and changes
+	version, err := c.fetchVersion(ctx, kind, key, cached.ID)
+	if err != nil || version != cached.Version {
+		return nil, false
+	}
+
+	c.logf("unchanged: %s", cacheKey)
+
+	return cached, true
+}
+
+func (c *Confluence) fetchVersion(ctx context.Context, kind, key, id string) (int, error) {
+	var agent *gorequest.SuperAgent
+
+	// v2 omits bodies unless asked for
+	if c.v2() {
+		agent = c.agent().Get(c.urlV2(kind + "s/" + url.PathEscape(id)))
+	} else {
+		agent = c.agent().Get(c.url("content/" + id)).
+			Query("type=" + kind).
+			Query("spaceKey=" + url.QueryEscape(key)).
+			Query("expand=version")
+	}
+
+	agent.Set("Accept", "application/json, */*")
+
+	_, res, err := c.end(ctx, agent)
+	if err != nil {
+		return 0, err
+	}
+
+	obj, err := parseJSON(res)
+	if err != nil {
+		return 0, err
+	}
+
+	number, _ := obj.Path("version.number").Data().(float64)
+
+	return int(number), nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestUnchangedPageBody(t *testing.T) {
+	var bodies, versions int32
+
+	confluence := newTestConfluence(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		// version checks only expand the version
+		if r.URL.Query().Get("expand") == "version" {
+			atomic.AddInt32(&versions, 1)
+			w.Write([]byte(`{"id":"1","type":"page","title":"Home","version":{"number":3}}`))
+			return
+		}
+
+		atomic.AddInt32(&bodies, 1)
+
+		page := `{"id":"1","type":"page","title":"Home","space":{"key":"ENG"},"body":{"view":{"value":"<p>home</p>"}},"version":{"number":3}}`
+		if strings.HasSuffix(r.URL.Path, "/content") {
+			page = `{"results":[` + page + `]}`
+		}
+
+		w.Write([]byte(page))
+	})
+
+	confluence.CacheTTL = 20 * time.Millisecond
+
+	tests := []struct {
+		name  string
+		fetch func() (*Page, error)
+	}{
+		{"id", func() (*Page, error) {
+			return confluence.GetPageByID(context.Background(), "ENG", "1")
+		}},
+		{"title", func() (*Page, error) {
+			return confluence.GetPageByTitle(context.Background(), "ENG", "Home")
+		}},
+	}
+
+	for _, test := range tests {
+		confluence.Reset()
+		atomic.StoreInt32(&bodies, 0)
+		atomic.StoreInt32(&versions, 0)
+
+		for i := 0; i < 2; i++ {
+			page, err := test.fetch()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if page.Body != "<p>home</p>" {
+				t.Errorf("%s: body = %q; want cached body", test.name, page.Body)
+			}
+
+			// let the entry expire
+			time.Sleep(30 * time.Millisecond)
+		}
+
+		if bodies != 1 || versions != 1 {
+			t.Errorf("%s: fetched %d bodies and %d versions; want 1 and 1", test.name, bodies, versions)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+func (c *Confluence) Warm(ctx context.Context, keys []string, allowed func(key string) bool) (int, error) {
+	// warm all listed spaces by default
+	if len(keys) == 0 {
+		spaces, err := c.GetSpaces(ctx)
+		if err != nil {
+			return 0, err
+		}
+
+		for _, space := range spaces {
+			keys = append(keys, space.Key)
+		}
+	}
+
+	// never fetch spaces that are not served
+	var served []string
+	for _, key := range keys {
+		if allowed == nil || allowed(key) {
+			served = append(served, key)
+		}
+	}
+
+	concurrency := c.WarmConcurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	w := &warmer{
+		confluence: c,
+		ctx:        ctx,
+		allowed:    allowed,
+		slots:      make(chan struct{}, concurrency),
+	}
+
+	for _, key := range served {
+		key := key
+		w.run(func() { w.space(key) })
+	}
+
+	w.wait.Wait()
+
+	return int(w.count), w.err
+}
+
+type warmer struct {
+	confluence *Confluence
+	ctx        context.Context
+	allowed    func(key string) bool
+	slots      chan struct{}
+	wait       sync.WaitGroup
+	count      int32
+
+	errMutex sync.Mutex
+	err      error
+}
+
+func (w *warmer) run(fn func()) {
+	w.wait.Add(1)
+
+	go func() {
+		defer w.wait.Done()
+
+		// bound concurrent requests
+		w.slots <- struct{}{}
+		defer func() { <-w.slots }()
+
+		if w.ctx.Err() == nil {
+			fn()
+		}
+	}()
+}
+
+func (w *warmer) space(key string) {
+	space, err := w.confluence.GetSpace(w.ctx, key)
+	if err != nil {
+		w.fail(err)
+		return
+	}
+
+	atomic.AddInt32(&w.count, 1)
+
+	if space.Homepage.ID != "" {
+		w.run(func() { w.page(key, space.Homepage.ID, 0) })
+	}
+}
+
+func (w *warmer) page(key, id string, depth int) {
+	if _, err := w.confluence.GetPageByID(w.ctx, key, id); err != nil {
+		w.fail(err)
+		return
+	}
+
+	atomic.AddInt32(&w.count, 1)
+
+	// descend into children up to the configured depth
+	if depth >= w.confluence.WarmDepth {
+		return
+	}
+
+	children, err := w.confluence.GetChildPages(w.ctx, id)
+	if err != nil {
+		w.fail(err)
+		return
+	}
+
+	for _, child := range children {
+		// children moved to other spaces are checked again
+		if child.SpaceKey != "" && w.allowed != nil && !w.allowed(child.SpaceKey) {
+			continue
+		}
+
+		child := child
+		w.run(func() { w.page(key, child.ID, depth+1) })
+	}
+}
+
+func (w *warmer) fail(err error) {
+	w.confluence.logf("warm error: %s", err.Error())
+
+	// keep warming but report the first failure
+	w.errMutex.Lock()
+	if w.err == nil {
+		w.err = err
+	}
+	w.errMutex.Unlock()
+}
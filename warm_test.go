@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestWarmAllowed(t *testing.T) {
+	var mutex sync.Mutex
+	var fetched []string
+
+	confluence := newTestConfluence(t, func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		fetched = append(fetched, r.URL.Path)
+		mutex.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.URL.Path {
+		case "/wiki/rest/api/space":
+			w.Write([]byte(`{"results":[{"key":"ENG","name":"Engineering","homepage":{"id":"1","title":"Home"}},{"key":"INTERNAL","name":"Internal","homepage":{"id":"2","title":"Home"}}],"size":2}`))
+		case "/wiki/rest/api/space/ENG":
+			w.Write([]byte(`{"key":"ENG","name":"Engineering","homepage":{"id":"1","title":"Home"}}`))
+		case "/wiki/rest/api/space/INTERNAL":
+			w.Write([]byte(`{"key":"INTERNAL","name":"Internal","homepage":{"id":"2","title":"Home"}}`))
+		case "/wiki/rest/api/content/1":
+			w.Write([]byte(`{"id":"1","type":"page","title":"Home","space":{"key":"ENG"}}`))
+		case "/wiki/rest/api/content/1/child/page":
+			w.Write([]byte(`{"results":[{"id":"3","type":"page","title":"Moved","space":{"key":"INTERNAL"}}],"size":1}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"statusCode":404}`))
+		}
+	})
+
+	confluence.WarmDepth = 1
+
+	c := &Convergence{DeniedSpaces: []string{"INTERNAL"}}
+
+	tests := []struct {
+		keys []string
+	}{
+		{nil},
+		{[]string{"ENG", "INTERNAL"}},
+	}
+
+	for _, test := range tests {
+		confluence.Reset()
+		fetched = nil
+
+		if _, err := confluence.Warm(context.Background(), test.keys, c.spaceAllowed); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, path := range fetched {
+			if strings.Contains(path, "INTERNAL") || strings.HasSuffix(path, "/content/2") || strings.HasSuffix(path, "/content/3") {
+				t.Errorf("Warm(%v) fetched denied %s", test.keys, path)
+			}
+		}
+		if !strings.Contains(strings.Join(fetched, " "), "/content/1/child/page") {
+			t.Errorf("Warm(%v) = %v; want allowed pages", test.keys, fetched)
+		}
+	}
+}